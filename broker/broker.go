@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "net/http/pprof"
@@ -20,6 +21,9 @@ type Options struct {
 	RequestTimeoutSec     time.Duration
 	LogsDir               string
 	PublishLoggingEnabled bool
+	// Clients sending keepalive pings are disconnected when no message is
+	// received from them during this duration. Zero disables the timeout.
+	KeepaliveTimeout time.Duration
 }
 
 type Monitoring struct {
@@ -56,6 +60,9 @@ type Broker struct {
 	publishLoggingRoot    string
 	publishLoggingLoggers sync.Map // map[string]*os.File
 
+	// Counter used to identify in-process connections
+	localConnId uint64
+
 	// The broker is started
 	startedCh chan struct{}
 	// The broker has cellaserv service registered
@@ -87,19 +94,50 @@ func (b *Broker) handle(conn net.Conn) {
 	for {
 		closed, msgBytes, msg, err := common.RecvMessage(conn)
 		if err != nil {
+			if common.IsTimeout(err) {
+				b.logger.Warnf("Client timed out: %s", c)
+				break
+			}
 			b.logger.Errorf("Could not receive message: %s", err)
 		}
 		if closed {
 			b.logger.Infof("Client disconnected: %s", c)
 			break
 		}
+		if msg == nil {
+			continue
+		}
 		err = b.handleMessage(c, msgBytes, msg)
 		if err != nil {
 			b.logger.Errorf("Could not handle message: %s", err)
 		}
+		if c.keepalive && b.Options.KeepaliveTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(b.Options.KeepaliveTimeout))
+		}
 	}
 
 	b.removeClient(c)
+	conn.Close()
+}
+
+// ServeConn handles a client connected through another transport than the
+// broker listener, e.g. WebSocket. It returns when the client disconnects.
+func (b *Broker) ServeConn(conn net.Conn) {
+	b.handle(conn)
+}
+
+// DialLocal returns a new in-process connection to the broker, for clients
+// running in the same process, e.g. in tests or single-binary deployments.
+func (b *Broker) DialLocal() (net.Conn, error) {
+	select {
+	case <-b.quitCh:
+		return nil, fmt.Errorf("Broker is stopped")
+	default:
+	}
+	id := atomic.AddUint64(&b.localConnId, 1)
+	clientConn, brokerConn := common.Pipe(fmt.Sprintf("local:%d", id), "broker")
+	go b.handle(brokerConn)
+	return clientConn, nil
 }
 
 func (b *Broker) logUnmarshalError(msg []byte) {
@@ -153,6 +191,24 @@ func (b *Broker) handleMessage(c *client, msgBytes []byte, msg *cellaserv.Messag
 		}
 		b.handleSubscribe(c, sub)
 		return nil
+	case common.MessageUnregister:
+		unregister := &cellaserv.Register{}
+		err = proto.Unmarshal(msgContent, unregister)
+		if err != nil {
+			b.logUnmarshalError(msgContent)
+			return fmt.Errorf("Could not unmarshal unregister: %s", err)
+		}
+		b.handleUnregister(c, unregister)
+		return nil
+	case common.MessageUnsubscribe:
+		sub := &cellaserv.Subscribe{}
+		err = proto.Unmarshal(msgContent, sub)
+		if err != nil {
+			b.logUnmarshalError(msgContent)
+			return fmt.Errorf("Could not unmarshal unsubscribe: %s", err)
+		}
+		b.handleUnsubscribe(c, sub)
+		return nil
 	case cellaserv.Message_Publish:
 		pub := &cellaserv.Publish{}
 		err = proto.Unmarshal(msgContent, pub)
@@ -162,6 +218,11 @@ func (b *Broker) handleMessage(c *client, msgBytes []byte, msg *cellaserv.Messag
 		}
 		b.handlePublish(c, msgBytes, pub)
 		return nil
+	case common.MessagePing:
+		b.handlePing(c, msgContent)
+		return nil
+	case common.MessagePong:
+		return nil
 	default:
 		return fmt.Errorf("Unknown message type: %d", msg.Type)
 	}
@@ -202,10 +263,9 @@ func (b *Broker) Run(ctx context.Context) error {
 	l, err := net.Listen("tcp", b.Options.ListenAddress)
 	if err != nil {
 		b.logger.Errorf("Could not listen on address %s: %s", b.Options.ListenAddress, err)
-		errCh <- err
-	} else {
-		defer l.Close()
+		return err
 	}
+	defer l.Close()
 
 	go b.serve(l, errCh)
 
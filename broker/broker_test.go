@@ -28,7 +28,7 @@ func brokerTestWithOptions(t *testing.T, options Options, testFn func(b *Broker)
 		t.Helper()
 		err := broker.Run(ctxBroker)
 		if err != nil {
-			t.Fatalf("Could not start broker: %s", err)
+			t.Errorf("Could not start broker: %s", err)
 		}
 	}()
 
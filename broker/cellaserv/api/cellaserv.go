@@ -40,3 +40,23 @@ type EventInfoJSON struct {
 }
 
 type ListEventsResponse []EventInfoJSON
+
+// Client services
+
+// MethodJSON describes a method of a service, returned by list_methods.
+type MethodJSON struct {
+	Name string `json:"name"`
+	Doc  string `json:"doc,omitempty"`
+}
+
+type ListMethodsResponse []MethodJSON
+
+// HandlerPanicJSON describes a panic of a request handler, published by the
+// client in the log.<service>.panic event.
+type HandlerPanicJSON struct {
+	Service        string `json:"service"`
+	Identification string `json:"identification"`
+	Method         string `json:"method"`
+	Panic          string `json:"panic"`
+	Stack          string `json:"stack"`
+}
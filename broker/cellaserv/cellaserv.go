@@ -31,7 +31,7 @@ func (cs *Cellaserv) Registered() chan struct{} {
 }
 
 // whoami sends back the client info of the sender
-func (cs *Cellaserv) whoami(req *cellaserv.Request) (interface{}, error) {
+func (cs *Cellaserv) whoami(ctx context.Context, req *cellaserv.Request) (interface{}, error) {
 	client, err := cs.broker.GetRequestSender(req)
 	if err != nil {
 		return nil, err
@@ -40,7 +40,7 @@ func (cs *Cellaserv) whoami(req *cellaserv.Request) (interface{}, error) {
 }
 
 // nameClient attaches a name to the client that sent the request.
-func (cs *Cellaserv) nameClient(req *cellaserv.Request) (interface{}, error) {
+func (cs *Cellaserv) nameClient(ctx context.Context, req *cellaserv.Request) (interface{}, error) {
 	var data api.NameClientRequest
 	err := json.Unmarshal(req.Data, &data)
 	if err != nil {
@@ -55,7 +55,7 @@ func (cs *Cellaserv) nameClient(req *cellaserv.Request) (interface{}, error) {
 }
 
 // registerService registers a new cellaserv service.
-func (cs *Cellaserv) registerService(req *cellaserv.Request) (interface{}, error) {
+func (cs *Cellaserv) registerService(ctx context.Context, req *cellaserv.Request) (interface{}, error) {
 	var data api.RegisterServiceRequest
 	err := json.Unmarshal(req.Data, &data)
 	if err != nil {
@@ -79,29 +79,29 @@ func (cs *Cellaserv) registerService(req *cellaserv.Request) (interface{}, error
 }
 
 // listClients replies with the list of currently connected clients
-func (cs *Cellaserv) listClients(*cellaserv.Request) (interface{}, error) {
+func (cs *Cellaserv) listClients(context.Context, *cellaserv.Request) (interface{}, error) {
 	return cs.broker.GetClientsJSON(), nil
 }
 
 // listServices retuns the list of services in the broker
-func (cs *Cellaserv) listServices(*cellaserv.Request) (interface{}, error) {
+func (cs *Cellaserv) listServices(context.Context, *cellaserv.Request) (interface{}, error) {
 	return cs.broker.GetServicesJSON(), nil
 }
 
 // listEvents replies with the list of subscribers
-func (cs *Cellaserv) listEvents(*cellaserv.Request) (interface{}, error) {
+func (cs *Cellaserv) listEvents(context.Context, *cellaserv.Request) (interface{}, error) {
 	return cs.broker.GetEventsJSON(), nil
 }
 
 // shutdown quits the broker
-func (cs *Cellaserv) shutdown(*cellaserv.Request) (interface{}, error) {
+func (cs *Cellaserv) shutdown(context.Context, *cellaserv.Request) (interface{}, error) {
 	cs.logger.Info("[Cellaserv] Shutting down.")
 	close(cs.broker.Quit())
 	return nil, nil
 }
 
 // handleSpy registers the connection as a `py of a service
-func (cs *Cellaserv) handleSpy(req *cellaserv.Request) (interface{}, error) {
+func (cs *Cellaserv) handleSpy(ctx context.Context, req *cellaserv.Request) (interface{}, error) {
 	var data api.SpyRequest
 	err := json.Unmarshal(req.Data, &data)
 	if err != nil {
@@ -126,11 +126,11 @@ func (cs *Cellaserv) handleSpy(req *cellaserv.Request) (interface{}, error) {
 }
 
 // version return the version of cellaserv
-func version(req *cellaserv.Request) (interface{}, error) {
+func version(ctx context.Context, req *cellaserv.Request) (interface{}, error) {
 	return common.Version, nil
 }
 
-func (cs *Cellaserv) getLogs(req *cellaserv.Request) (interface{}, error) {
+func (cs *Cellaserv) getLogs(ctx context.Context, req *cellaserv.Request) (interface{}, error) {
 	var data api.GetLogsRequest
 	err := json.Unmarshal(req.Data, &data)
 	if err != nil {
@@ -24,14 +24,14 @@ func WithTestBrokerOptions(t *testing.T, options broker.Options, testFn func(cli
 	go func() {
 		err := broker.Run(ctxBroker)
 		if err != nil {
-			t.Fatalf("Could not start broker: %s", err)
+			t.Errorf("Could not start broker: %s", err)
 		}
 	}()
 
 	go func() {
 		err := cs.Run(ctxCellaserv)
 		if err != nil {
-			t.Fatalf("Could not start cellaserv: %s", err)
+			t.Errorf("Could not start cellaserv: %s", err)
 		}
 	}()
 
@@ -21,6 +21,7 @@ type client struct {
 	spying     []*service    // services spied by this client
 	services   []*service    // services registered by this clietn
 	subscribes []string      // events subscribed by the client
+	keepalive  bool          // the client sends keepalive pings
 	logger     common.Logger // client logger
 }
 
@@ -102,28 +103,34 @@ func (b *Broker) sendReplyError(c *client, req *cellaserv.Request, errType cella
 func (b *Broker) removeServicesOnClient(c *client) {
 	// TODO: notify goroutines waiting for acks for this service
 	for _, s := range c.services {
-		c.logger.Infof("Remove service %s", s)
-		pubJSON, _ := json.Marshal(s.JSONStruct())
-		b.cellaservPublishBytes(logLostService, pubJSON)
-
-		b.servicesMtx.Lock()
-		delete(b.services[s.Name], s.Identification)
-		b.servicesMtx.Unlock()
-
-		// Close connections that spied this service
-		// TODO(halfr): do not close thoses connections, instead,
-		// spying and services and make sure that if the service
-		// reconnects, the spies are automatically re-added to this
-		// service.
-		s.spiesMtx.RLock()
-		for _, c := range s.spies {
-			c.logger.Debugf("Close spy conn: %s", c)
-			if err := c.conn.Close(); err != nil {
-				c.logger.Errorf("Could not close connection: %s", err)
-			}
+		b.removeService(s)
+	}
+}
+
+// removeService removes the service from the services map and closes the
+// connections spying on it.
+func (b *Broker) removeService(s *service) {
+	s.client.logger.Infof("Remove service %s", s)
+	pubJSON, _ := json.Marshal(s.JSONStruct())
+	b.cellaservPublishBytes(logLostService, pubJSON)
+
+	b.servicesMtx.Lock()
+	delete(b.services[s.Name], s.Identification)
+	b.servicesMtx.Unlock()
+
+	// Close connections that spied this service
+	// TODO(halfr): do not close thoses connections, instead,
+	// spying and services and make sure that if the service
+	// reconnects, the spies are automatically re-added to this
+	// service.
+	s.spiesMtx.RLock()
+	for _, c := range s.spies {
+		c.logger.Debugf("Close spy conn: %s", c)
+		if err := c.conn.Close(); err != nil {
+			c.logger.Errorf("Could not close connection: %s", err)
 		}
-		s.spiesMtx.RLock()
 	}
+	s.spiesMtx.RUnlock()
 }
 
 func (b *Broker) removeSubscriptionsOfClient(c *client) {
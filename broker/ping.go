@@ -0,0 +1,18 @@
+package broker
+
+import (
+	cellaserv "github.com/evolutek/cellaserv3-protobuf"
+	"github.com/evolutek/cellaserv3/common"
+)
+
+// handlePing answers a ping. From now on, the client is expected to send
+// messages regularly and is disconnected after Options.KeepaliveTimeout of
+// silence.
+func (b *Broker) handlePing(c *client, content []byte) {
+	c.keepalive = true
+
+	msg := &cellaserv.Message{Type: common.MessagePong, Content: content}
+	if err := common.SendMessage(c.conn, msg); err != nil {
+		c.logger.Errorf("Could not send pong: %s", err)
+	}
+}
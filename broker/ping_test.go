@@ -0,0 +1,33 @@
+package broker
+
+import (
+	"testing"
+	"time"
+
+	cellaserv "github.com/evolutek/cellaserv3-protobuf"
+	"github.com/evolutek/cellaserv3/common"
+	"github.com/evolutek/cellaserv3/testutil"
+)
+
+func TestKeepalive(t *testing.T) {
+	options := Options{KeepaliveTimeout: 100 * time.Millisecond}
+	brokerTestWithOptions(t, options, func(b *Broker) {
+		conn := testutil.Dial(t)
+		defer conn.Close()
+
+		ping := &cellaserv.Message{Type: common.MessagePing, Content: []byte("foo")}
+		conn.Write(testutil.MessageForNetwork(t, ping))
+
+		// The broker answers with the same content
+		_, _, msg, err := common.RecvMessage(conn)
+		testutil.Ok(t, err)
+		testutil.Equals(t, common.MessagePong, msg.GetType())
+		testutil.Equals(t, []byte("foo"), msg.GetContent())
+
+		// The broker closes the connection after the keepalive timeout
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		closed, _, _, err := common.RecvMessage(conn)
+		testutil.Ok(t, err)
+		testutil.Assert(t, closed, "connection was not closed")
+	})
+}
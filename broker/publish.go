@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	cellaserv "github.com/evolutek/cellaserv3-protobuf"
+	"github.com/evolutek/cellaserv3/common"
 	"github.com/golang/protobuf/proto"
 )
 
@@ -20,11 +21,34 @@ const (
 )
 
 func (b *Broker) handlePublish(c *client, msgBytes []byte, pub *cellaserv.Publish) {
-	c.logger.Infof("Publishes event %q", pub.Event)
-	b.doPublish(msgBytes, pub)
+	if traceId := common.GetTraceId(pub); traceId != "" {
+		c.logger.Infof("Publishes event %q [trace %s]", pub.Event, traceId)
+	} else {
+		c.logger.Infof("Publishes event %q", pub.Event)
+	}
+
+	ackId, ackMode := common.GetPublishAck(pub)
+	if ackMode == common.AckReceipt {
+		b.sendPublishAck(c, ackId, nil)
+	}
+
+	subscribers := b.doPublish(msgBytes, pub)
+
+	if ackMode == common.AckFanout {
+		data, _ := json.Marshal(subscribers)
+		b.sendPublishAck(c, ackId, data)
+	}
+}
+
+// sendPublishAck acknowledges a publish with a reply having the ack id.
+func (b *Broker) sendPublishAck(c *client, ackId uint64, data []byte) {
+	c.logger.Debugf("Acknowledging publish %d", ackId)
+	b.sendReply(c, &cellaserv.Request{Id: ackId}, data)
 }
 
-func (b *Broker) doPublish(msgBytes []byte, pub *cellaserv.Publish) {
+// doPublish sends the publish to the matching subscribers and returns their
+// number.
+func (b *Broker) doPublish(msgBytes []byte, pub *cellaserv.Publish) int {
 	// Set of subscribers for this publish
 	subs := make(map[*client]bool)
 
@@ -56,6 +80,7 @@ func (b *Broker) doPublish(msgBytes []byte, pub *cellaserv.Publish) {
 		c.logger.Debugf("Receives event %q", pub.Event)
 		b.sendRawMessage(c.conn, msgBytes)
 	}
+	return len(subs)
 }
 
 // cellaservPublishBytes sends a publish message from cellaserv
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	cellaserv "github.com/evolutek/cellaserv3-protobuf"
+	"github.com/evolutek/cellaserv3/common"
 	"github.com/evolutek/cellaserv3/testutil"
 	"github.com/golang/protobuf/proto"
 )
@@ -71,3 +72,38 @@ func TestPublishPattern(t *testing.T) {
 		testutil.Equals(t, msgPublish.GetEvent(), topic)
 	})
 }
+
+func TestPublishAck(t *testing.T) {
+	brokerTest(t, func(b *Broker) {
+		sub := testutil.Dial(t)
+		defer sub.Close()
+		pub := testutil.Dial(t)
+		defer pub.Close()
+
+		const topic = "emergency_stop"
+		sub.Write(testutil.MakeMessageSubscribe(t, topic))
+		time.Sleep(50 * time.Millisecond)
+
+		recvAck := func() *cellaserv.Reply {
+			msg := testutil.RecvMessage(t, pub)
+			testutil.MsgTypeIs(t, msg, cellaserv.Message_Reply)
+			rep := &cellaserv.Reply{}
+			testutil.Ok(t, proto.Unmarshal(msg.GetContent(), rep))
+			return rep
+		}
+
+		pub.Write(testutil.MakeMessagePublishAck(t, topic, 42, common.AckReceipt))
+		rep := recvAck()
+		testutil.Equals(t, uint64(42), rep.GetId())
+		testutil.Equals(t, 0, len(rep.GetData()))
+
+		pub.Write(testutil.MakeMessagePublishAck(t, topic, 43, common.AckFanout))
+		rep = recvAck()
+		testutil.Equals(t, uint64(43), rep.GetId())
+		testutil.Equals(t, "1", string(rep.GetData()))
+
+		// The subscriber received both events
+		testutil.MsgTypeIs(t, testutil.RecvMessage(t, sub), cellaserv.Message_Publish)
+		testutil.MsgTypeIs(t, testutil.RecvMessage(t, sub), cellaserv.Message_Publish)
+	})
+}
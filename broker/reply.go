@@ -2,6 +2,7 @@ package broker
 
 import (
 	cellaserv "github.com/evolutek/cellaserv3-protobuf"
+	"github.com/evolutek/cellaserv3/common"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -21,6 +22,14 @@ func (b *Broker) handleReply(c *client, msgRaw []byte, rep *cellaserv.Reply) {
 		logger.Errorf("Could not find a matching request.")
 		return
 	}
+
+	// Partial replies are forwarded, the request is still tracked until the
+	// final reply
+	if common.IsPartialReply(rep) {
+		logger.Debugf("Sending partial reply to destination client: %s", reqTrack.sender)
+		b.sendRawMessage(reqTrack.sender.conn, msgRaw)
+		return
+	}
 	b.reqIdsMtx.Lock()
 	delete(b.reqIds, id)
 	b.reqIdsMtx.Unlock()
@@ -6,6 +6,7 @@ import (
 
 	cellaserv "github.com/evolutek/cellaserv3-protobuf"
 	"github.com/evolutek/cellaserv3/common"
+	"github.com/golang/protobuf/proto"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 )
@@ -24,10 +25,12 @@ func (b *Broker) handleRequest(c *client, msgRaw []byte, req *cellaserv.Request)
 	ident := req.ServiceIdentification
 
 	logger := log.WithFields(log.Fields{
-		"module": "request",
-		"client": c.String(),
-		"id":     id,
-		"method": method,
+		"module":   "request",
+		"client":   c.String(),
+		"id":       id,
+		"method":   method,
+		"priority": common.GetPriority(req),
+		"trace":    common.GetTraceId(req),
 	})
 
 	idents, ok := b.services[name]
@@ -57,7 +60,21 @@ func (b *Broker) handleRequest(c *client, msgRaw []byte, req *cellaserv.Request)
 			b.sendReplyError(c, req, cellaserv.Reply_Error_Timeout)
 		}
 	}
-	timer := time.AfterFunc(b.Options.RequestTimeoutSec*time.Second, handleTimeout)
+	// The request times out at the deadline set by the requester, if earlier
+	// than the broker timeout
+	deadline := time.Now().Add(b.Options.RequestTimeoutSec * time.Second)
+	if d, ok := common.GetDeadline(req); ok && d.Before(deadline) {
+		deadline = d
+	}
+	timer := time.AfterFunc(time.Until(deadline), handleTimeout)
+
+	// Let the service know when the request will time out
+	msgRaw, err := b.setRequestDeadline(req, deadline)
+	if err != nil {
+		logger.Errorf("Could not set request deadline: %s", err)
+		timer.Stop()
+		return
+	}
 
 	// The ID is used to track the sender of the request
 	reqTrack := &requestTracking{
@@ -83,6 +100,18 @@ func (b *Broker) handleRequest(c *client, msgRaw []byte, req *cellaserv.Request)
 	srvc.spiesMtx.RUnlock()
 }
 
+// setRequestDeadline sets the deadline of the request and returns the new
+// message to forward.
+func (b *Broker) setRequestDeadline(req *cellaserv.Request, deadline time.Time) ([]byte, error) {
+	common.SetDeadline(req, deadline)
+
+	reqBytes, err := proto.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(&cellaserv.Message{Type: cellaserv.Message_Request, Content: reqBytes})
+}
+
 func (b *Broker) GetRequestSender(req *cellaserv.Request) (*client, error) {
 	b.reqIdsMtx.RLock()
 	defer b.reqIdsMtx.RUnlock()
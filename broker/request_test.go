@@ -110,3 +110,42 @@ func TestRequestNoService(t *testing.T) {
 		}
 	})
 }
+
+func TestRequestDeadline(t *testing.T) {
+	brokerTest(t, func(b *Broker) {
+		connService := testutil.Dial(t)
+		defer connService.Close()
+
+		connClient := testutil.Dial(t)
+		defer connClient.Close()
+
+		connService.Write(testutil.MakeMessageRegister(t, "slow", ""))
+		time.Sleep(50 * time.Millisecond)
+
+		deadline := time.Now().Add(100 * time.Millisecond)
+		req := &cellaserv.Request{ServiceName: "slow", Method: "wait", Id: 42}
+		common.SetDeadline(req, deadline)
+		reqBytes, err := proto.Marshal(req)
+		testutil.Ok(t, err)
+		connClient.Write(testutil.MessageForNetwork(t, &cellaserv.Message{Type: cellaserv.Message_Request, Content: reqBytes}))
+
+		// The service receives the deadline of the requester
+		msg := testutil.RecvMessage(t, connService)
+		testutil.MsgTypeIs(t, msg, cellaserv.Message_Request)
+		forwarded := &cellaserv.Request{}
+		testutil.Ok(t, proto.Unmarshal(msg.GetContent(), forwarded))
+		d, ok := common.GetDeadline(forwarded)
+		testutil.Assert(t, ok, "deadline not forwarded")
+		testutil.Assert(t, d.Equal(deadline), "forwarded deadline %s, expected %s", d, deadline)
+
+		// The broker times out the request at the deadline, before its own
+		// request timeout
+		msg = testutil.RecvMessage(t, connClient)
+		testutil.MsgTypeIs(t, msg, cellaserv.Message_Reply)
+		reply := &cellaserv.Reply{}
+		testutil.Ok(t, proto.Unmarshal(msg.GetContent(), reply))
+		testutil.Equals(t, uint64(42), reply.GetId())
+		testutil.Equals(t, cellaserv.Reply_Error_Timeout, reply.GetError().GetType())
+		testutil.Assert(t, time.Since(deadline) < time.Second, "request timed out late: %s", time.Since(deadline))
+	})
+}
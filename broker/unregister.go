@@ -0,0 +1,25 @@
+package broker
+
+import (
+	cellaserv "github.com/evolutek/cellaserv3-protobuf"
+)
+
+// handleUnregister removes a service registered by the client, the other
+// services of the client are kept.
+func (b *Broker) handleUnregister(c *client, msg *cellaserv.Register) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for i, s := range c.services {
+		if s.Name == msg.Name && s.Identification == msg.Identification {
+			// Remove from slice
+			c.services[i] = c.services[len(c.services)-1]
+			c.services = c.services[:len(c.services)-1]
+
+			b.removeService(s)
+			return
+		}
+	}
+
+	c.logger.Warnf("Could not unregister service %s[%s]: not registered by this client", msg.Name, msg.Identification)
+}
@@ -0,0 +1,41 @@
+package broker
+
+import (
+	"testing"
+	"time"
+
+	cellaserv "github.com/evolutek/cellaserv3-protobuf"
+	"github.com/evolutek/cellaserv3/common"
+	"github.com/evolutek/cellaserv3/testutil"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestUnregister(t *testing.T) {
+	brokerTest(t, func(b *Broker) {
+		conn := testutil.Dial(t)
+		defer conn.Close()
+
+		conn.Write(testutil.MakeMessageRegister(t, "date", ""))
+		conn.Write(testutil.MakeMessageRegister(t, "time", ""))
+		time.Sleep(50 * time.Millisecond)
+
+		conn.Write(testutil.MakeMessageUnregister(t, "date", ""))
+		time.Sleep(50 * time.Millisecond)
+
+		b.servicesMtx.RLock()
+		_, dateFound := b.services["date"][""]
+		_, timeFound := b.services["time"][""]
+		b.servicesMtx.RUnlock()
+		testutil.Assert(t, !dateFound, "service was not unregistered")
+		testutil.Assert(t, timeFound, "other service of the client was unregistered")
+
+		// Requests to the unregistered service fail
+		conn.Write(testutil.MakeMessageRequest(t, "date", "", "time", nil))
+		_, _, msg, err := common.RecvMessage(conn)
+		testutil.Ok(t, err)
+		testutil.Equals(t, cellaserv.Message_Reply, msg.GetType())
+		reply := &cellaserv.Reply{}
+		testutil.Ok(t, proto.Unmarshal(msg.GetContent(), reply))
+		testutil.Equals(t, cellaserv.Reply_Error_NoSuchService, reply.GetError().GetType())
+	})
+}
@@ -0,0 +1,54 @@
+package broker
+
+import (
+	"strings"
+
+	cellaserv "github.com/evolutek/cellaserv3-protobuf"
+)
+
+func (b *Broker) handleUnsubscribe(c *client, sub *cellaserv.Subscribe) {
+	c.logger.Infof("Unsubscribes from event %q", sub.Event)
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	present := false
+	for i, pattern := range c.subscribes {
+		if pattern == sub.Event {
+			// Remove from slice
+			c.subscribes[i] = c.subscribes[len(c.subscribes)-1]
+			c.subscribes = c.subscribes[:len(c.subscribes)-1]
+			present = true
+			break
+		}
+	}
+	if !present {
+		c.logger.Infof("Client is not subscribed to %q", sub.Event)
+		return
+	}
+
+	removeConnFromList := func(subMap map[string][]*client) {
+		subs := subMap[sub.Event]
+		for i, subClient := range subs {
+			if subClient == c {
+				subs[i] = subs[len(subs)-1]
+				subMap[sub.Event] = subs[:len(subs)-1]
+				break
+			}
+		}
+		if len(subMap[sub.Event]) == 0 {
+			delete(subMap, sub.Event)
+		}
+	}
+
+	if strings.Contains(sub.Event, "*") {
+		b.subscriberMatchMapMtx.Lock()
+		removeConnFromList(b.subscriberMatchMap)
+		b.subscriberMatchMapMtx.Unlock()
+	} else {
+		b.subscriberMapMtx.Lock()
+		removeConnFromList(b.subscriberMap)
+		b.subscriberMapMtx.Unlock()
+	}
+
+	b.cellaservPublish(logLostSubscriber, logSubscriberJSON{sub.Event, c.id})
+}
@@ -0,0 +1,36 @@
+package broker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/evolutek/cellaserv3/testutil"
+)
+
+func TestUnsubscribe(t *testing.T) {
+	brokerTest(t, func(b *Broker) {
+		conn := testutil.Dial(t)
+		defer conn.Close()
+
+		const topic = "test"
+		const pattern = "test*"
+		conn.Write(testutil.MakeMessageSubscribe(t, topic))
+		conn.Write(testutil.MakeMessageSubscribe(t, pattern))
+		time.Sleep(50 * time.Millisecond)
+
+		conn.Write(testutil.MakeMessageUnsubscribe(t, topic))
+		conn.Write(testutil.MakeMessageUnsubscribe(t, pattern))
+		time.Sleep(50 * time.Millisecond)
+
+		// The client is no longer subscribed
+		b.subscriberMapMtx.RLock()
+		_, found := b.subscriberMap[topic]
+		b.subscriberMapMtx.RUnlock()
+		testutil.Assert(t, !found, "subscriber for %q was not removed", topic)
+
+		b.subscriberMatchMapMtx.RLock()
+		_, found = b.subscriberMatchMap[pattern]
+		b.subscriberMatchMapMtx.RUnlock()
+		testutil.Assert(t, !found, "subscriber for %q was not removed", pattern)
+	})
+}
@@ -129,7 +129,7 @@ func (h *Handler) apiPublish(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.client.Publish(event, body)
+	h.client.PublishRaw(event, body)
 }
 
 var upgrader = websocket.Upgrader{
@@ -185,7 +185,7 @@ func (h *Handler) apiSubscribe(w http.ResponseWriter, r *http.Request) {
 
 	go h.ping(ws, done)
 
-	err = h.client.SubscribeUntil(event,
+	_, err = h.client.SubscribeUntil(event,
 		func(eventName string, eventBytes []byte) bool {
 			msg := struct {
 				Name string `json:"name"`
@@ -212,6 +212,20 @@ func (h *Handler) apiSubscribe(w http.ResponseWriter, r *http.Request) {
 	<-done
 }
 
+// apiConnect serves the cellaserv protocol over websocket, for clients that
+// cannot open a TCP connection to the broker
+func (h *Handler) apiConnect(w http.ResponseWriter, r *http.Request) {
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		h.logger.Errorf("Could not upgrade: %s", err)
+		return
+	}
+	conn := common.NewWebSocketConn(ws)
+	defer conn.Close()
+
+	h.broker.ServeConn(conn)
+}
+
 // overview returns a page showing the list of connections, events and services
 func (h *Handler) handleOverview(w http.ResponseWriter, r *http.Request) {
 	h.logger.Debug("Serving overview")
@@ -379,6 +393,7 @@ func New(o *Options, logger common.Logger, broker *broker.Broker) *Handler {
 	router.Post("/api/v1/request/:service/:method", h.apiRequest)
 	router.Post("/api/v1/publish/:event", h.apiPublish)
 	router.Get("/api/v1/subscribe/:event", h.apiSubscribe)
+	router.Get("/api/v1/connect", h.apiConnect)
 	// TODO(halfr): spy
 
 	// Go debug
@@ -0,0 +1,35 @@
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/evolutek/cellaserv3/broker"
+	"github.com/evolutek/cellaserv3/common"
+)
+
+// withTestBroker runs testFn with a broker listening on the address. The
+// broker is stopped, and its listener closed, when testFn returns.
+func withTestBroker(t *testing.T, listenAddress string, testFn func(ClientOpts)) {
+	t.Helper()
+	ctxBroker, cancelBroker := context.WithCancel(context.Background())
+	b := broker.New(broker.Options{ListenAddress: listenAddress}, common.NewLogger("broker"))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := b.Run(ctxBroker); err != nil {
+			t.Errorf("Could not start broker: %s", err)
+		}
+	}()
+	select {
+	case <-b.Started():
+	case <-done:
+		t.FailNow()
+	}
+
+	testFn(ClientOpts{CellaservAddr: listenAddress})
+
+	cancelBroker()
+	<-done
+}
@@ -0,0 +1,95 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	cellaserv "github.com/evolutek/cellaserv3-protobuf"
+	"github.com/evolutek/cellaserv3/common"
+)
+
+// WithCache makes the stub cache the successful replies for ttl, by method
+// and request data. Only use it for methods returning slowly-changing values,
+// e.g. configuration or calibration data.
+func WithCache(ttl time.Duration) StubOption {
+	return func(s *ServiceStub) {
+		s.cache = newReplyCache(ttl)
+	}
+}
+
+// InvalidateCache removes the cached replies of the stub.
+func (s *ServiceStub) InvalidateCache() {
+	if s.cache != nil {
+		s.cache.clear()
+	}
+}
+
+type cacheKey struct {
+	method      string
+	contentType string
+	data        string
+}
+
+type cacheEntry struct {
+	reply   *cellaserv.Reply
+	expires time.Time
+}
+
+type replyCache struct {
+	ttl time.Duration
+
+	mtx     sync.Mutex
+	entries map[cacheKey]cacheEntry
+}
+
+func newReplyCache(ttl time.Duration) *replyCache {
+	return &replyCache{
+		ttl:     ttl,
+		entries: make(map[cacheKey]cacheEntry),
+	}
+}
+
+func keyOf(req *cellaserv.Request) cacheKey {
+	return cacheKey{
+		method:      req.GetMethod(),
+		contentType: common.GetContentType(req),
+		data:        string(req.GetData()),
+	}
+}
+
+// get returns the cached reply to the request, if not expired.
+func (c *replyCache) get(req *cellaserv.Request) (*cellaserv.Reply, bool) {
+	key := keyOf(req)
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.reply, true
+}
+
+// put caches the reply to the request, and removes the expired replies.
+func (c *replyCache) put(req *cellaserv.Request, reply *cellaserv.Reply) {
+	now := time.Now()
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+	c.entries[keyOf(req)] = cacheEntry{reply: reply, expires: now.Add(c.ttl)}
+}
+
+func (c *replyCache) clear() {
+	c.mtx.Lock()
+	c.entries = make(map[cacheKey]cacheEntry)
+	c.mtx.Unlock()
+}
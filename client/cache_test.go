@@ -0,0 +1,49 @@
+package client
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cellaserv "github.com/evolutek/cellaserv3-protobuf"
+	"github.com/evolutek/cellaserv3/testutil"
+)
+
+func TestStubCache(t *testing.T) {
+	withTestBroker(t, ":4201", func(opts ClientOpts) {
+		srvc := NewClient(opts)
+		defer srvc.Close()
+		s := srvc.NewService("config", "")
+		var calls int32
+		s.HandleRequestFunc("get", func(ctx context.Context, req *cellaserv.Request) (interface{}, error) {
+			return atomic.AddInt32(&calls, 1), nil
+		})
+		srvc.RegisterService(s)
+		time.Sleep(50 * time.Millisecond)
+
+		c := NewClient(opts)
+		defer c.Close()
+		stub := NewServiceStub(c, "config", "", WithCache(200*time.Millisecond))
+
+		for i := 0; i < 3; i++ {
+			resp, err := stub.Request("get", "speed")
+			testutil.Ok(t, err)
+			testutil.Equals(t, "1", string(resp))
+		}
+		// Different arguments are cached separately
+		resp, err := stub.Request("get", "accel")
+		testutil.Ok(t, err)
+		testutil.Equals(t, "2", string(resp))
+
+		stub.InvalidateCache()
+		resp, err = stub.Request("get", "speed")
+		testutil.Ok(t, err)
+		testutil.Equals(t, "3", string(resp))
+
+		time.Sleep(250 * time.Millisecond)
+		resp, err = stub.Request("get", "speed")
+		testutil.Ok(t, err)
+		testutil.Equals(t, "4", string(resp))
+	})
+}
@@ -1,9 +1,11 @@
 package client
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"math/rand"
 	"net"
 	"os"
@@ -22,14 +24,51 @@ import (
 const (
 	defaultCellaservPort = "4200"
 	defaultCellaservHost = "localhost"
+
+	// Number of events that can wait to be handled by a subscriber
+	defaultEventQueueSize = 64
+
+	// Maximum duration Close waits for the requests in flight
+	defaultCloseTimeout = 5 * time.Second
 )
 
-type subscriberHandler func(eventName string, eventData []byte)
-type subscriberUntilHandler func(eventName string, eventData []byte) bool
+// Event handlers are aliases so that the handlers of other implementations of
+// Interface have identical types.
+type subscriberHandler = func(eventName string, eventData []byte)
+type subscriberUntilHandler = func(eventName string, eventData []byte) bool
+
+// SubscriptionId identifies a subscription made with Subscribe or
+// SubscribeUntil.
+type SubscriptionId uint64
 
 type subscriber struct {
+	id           SubscriptionId
 	eventPattern string
-	handle       subscriberUntilHandler
+	handle       func(e Event) bool
+	// Events waiting to be handled by this subscriber
+	events chan Event
+	// Called when the subscriber stops, may be nil
+	onDone func()
+}
+
+// Event is a received publish.
+type Event struct {
+	Name string
+	Data []byte
+	// Content type of the data, empty for JSON
+	ContentType string
+	// Trace id of the publish, see WithTraceId
+	TraceId string
+}
+
+// Unmarshal decodes the data of the event with the codec selected by the
+// publisher.
+func (e Event) Unmarshal(v interface{}) error {
+	codec, ok := codecForContentType(e.ContentType)
+	if !ok {
+		return fmt.Errorf("Unknown event content type: %q", e.ContentType)
+	}
+	return codec.Unmarshal(e.Data, v)
 }
 
 type spyHandler func(req *cellaserv.Request, rep *cellaserv.Reply)
@@ -54,27 +93,69 @@ type Client struct {
 
 	logger common.Logger
 
-	// Connection to cellaserv
+	// Size of the event queue of each subscriber
+	eventQueueSize int
+	// Codec of requests and publishes
+	codec Codec
+
+	// Connection to cellaserv, replaced when reconnecting
 	conn net.Conn
+	// Closed when the current connection is lost
+	connLostCh chan struct{}
+	// Dials a new connection after the connection is lost, nil to quit
+	// instead
+	redial            func() (net.Conn, error)
+	reconnectInterval time.Duration
+	// Connection lifecycle hooks
+	onDisconnect func(c *Client, err error)
+	onReconnect  func(c *Client)
+	// Name sent to cellaserv
+	name string
 	// Services registered on this client
 	services map[string]map[string]*service
 	// Subscribers on this client
 	subscribers []*subscriber
+	// Nonce used to compute subscription ids
+	currentSubscriptionId uint64
+	// Last events received for each subscribed event pattern, replayed to
+	// new subscribers
+	replaySize    int
+	replayBuffers map[string]*eventRing
 	// Spies on this client
 	spies map[string]map[string][]spyHandler
 	// Spy requests missing their associated replies
 	spyRequestsPending map[uint64]*spyPendingRequest
 	// Map of request ids to their replies
 	requestsInFlight map[uint64]chan *cellaserv.Reply
+	// Handlers of the partial replies of streamed requests, by request and
+	// by request id once sent
+	streams        map[*cellaserv.Request]PartialReplyHandler
+	partialReplies map[uint64]PartialReplyHandler
+	// Additional connections to cellaserv used to send requests
+	requestConns []net.Conn
+	// Counter used to spread requests over the connections
+	requestConnIdx uint32
+	// Interceptors of outgoing requests and publishes
+	requestInterceptors []RequestInterceptor
+	publishInterceptors []PublishInterceptor
 	// Broker identifier for this client
 	clientId string
 
 	// Incoming messages
 	msgCh chan *cellaserv.Message
 	// TODO(halfr): this should be renamed "remoteClosed"
-	closeCh chan struct{}
-	quit    bool
-	quitCh  chan struct{}
+	closeCh  chan struct{}
+	quitCh   chan struct{}
+	quitOnce sync.Once
+
+	// Requests in flight and request handlers in progress, waited for by
+	// Close
+	inFlight     sync.WaitGroup
+	closing      bool
+	closeTimeout time.Duration
+
+	// Maximum duration to wait for a reply, zero for no limit
+	requestTimeout time.Duration
 }
 
 // clientId returns the broker identifier for this client
@@ -87,45 +168,97 @@ func (c *Client) ClientId() string {
 	// Fetch, store and return
 	respBytes, err := c.Cs.Request("whoami", nil)
 	if err != nil {
-		log.Printf("cellaserv.whoami() query failed: %s", err)
+		c.logger.Errorf("cellaserv.whoami() query failed: %s", err)
 		return ""
 	}
 	json.Unmarshal(respBytes, &c.clientId)
 	return c.clientId
 }
 
-func (c *Client) sendRequestWaitForReply(req *cellaserv.Request) *cellaserv.Reply {
+func (c *Client) sendRequestWaitForReply(req *cellaserv.Request) (*cellaserv.Reply, error) {
+	if !c.track() {
+		return nil, ErrConnectionClosed
+	}
+	defer c.inFlight.Done()
+
+	// The request timeout only shortens the deadline set by the requester
+	if c.requestTimeout > 0 {
+		deadline := time.Now().Add(c.requestTimeout)
+		if d, ok := common.GetDeadline(req); !ok || deadline.Before(d) {
+			common.SetDeadline(req, deadline)
+		}
+	}
+	deadline, _ := common.GetDeadline(req)
+
 	// Add message Id and increment nonce
 	req.Id = atomic.AddUint64(&c.currentRequestId, 1)
 	reqBytes, err := proto.Marshal(req)
 	if err != nil {
-		panic(fmt.Sprintf("Could not marshal request: %s", err))
+		return nil, fmt.Errorf("Could not marshal request: %s", err)
 	}
 
+	// Track request id
+	replyCh := make(chan *cellaserv.Reply, 1)
+	c.mtx.Lock()
 	if _, ok := c.requestsInFlight[req.Id]; ok {
+		c.mtx.Unlock()
 		panic(fmt.Sprintf("Duplicate Request Id: %d", req.Id))
 	}
+	c.requestsInFlight[req.Id] = replyCh
+	if partial, ok := c.streams[req]; ok {
+		c.partialReplies[req.Id] = partial
+	}
+	c.mtx.Unlock()
 
-	// Track request id
-	c.requestsInFlight[req.Id] = make(chan *cellaserv.Reply)
+	defer func() {
+		c.mtx.Lock()
+		delete(c.requestsInFlight, req.Id)
+		delete(c.partialReplies, req.Id)
+		c.mtx.Unlock()
+	}()
 
 	msgType := cellaserv.Message_Request
 	msg := cellaserv.Message{Type: msgType, Content: reqBytes}
 
-	err = common.SendMessage(c.conn, &msg)
+	lost := c.connLost()
+	err = common.SendMessage(c.requestConn(), &msg)
 	if err != nil {
-		panic(fmt.Sprintf("Could not send message: %s", err))
+		return nil, &TransportError{Err: err}
+	}
+
+	return c.waitReply(replyCh, lost, deadline)
+}
+
+// waitReply waits for the reply sent on replyCh, until the deadline unless it
+// is zero. The request fails when the connection is lost, as signaled by
+// lost.
+func (c *Client) waitReply(replyCh chan *cellaserv.Reply, lost <-chan struct{}, deadline time.Time) (*cellaserv.Reply, error) {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
 	}
 
-	// Wait for reply
-	return <-c.requestsInFlight[req.Id]
+	select {
+	case reply := <-replyCh:
+		return reply, nil
+	case <-timeout:
+		return nil, ErrTimeout
+	case <-lost:
+		return nil, ErrConnectionClosed
+	case <-c.closeCh:
+		return nil, ErrConnectionClosed
+	case <-c.quitCh:
+		return nil, ErrConnectionClosed
+	}
 }
 
 func (c *Client) handleRequest(req *cellaserv.Request) error {
 	name := req.GetServiceName()
 	ident := req.GetServiceIdentification()
 	method := req.GetMethod()
-	c.logger.Debugf("Received request %s[%s].%s", name, ident, method)
+	c.logger.Debugf("Received request %s[%s].%s [trace %s]", name, ident, method, common.GetTraceId(req))
 
 	// Dispatch request to spies
 	hasSpied := false
@@ -160,16 +293,65 @@ func (c *Client) handleRequest(req *cellaserv.Request) error {
 		return fmt.Errorf("No such service identification for %s: %s, has: %v", name, ident, idents)
 	}
 
-	replyData, replyErr := srvc.handleRequest(req, method)
-	c.sendRequestReply(req, replyData, replyErr)
+	codec := srvc.replyCodec(req)
+	if !c.track() {
+		c.sendRequestReply(req, nil, codec.ContentType(), fmt.Errorf("Client is closing"))
+		return nil
+	}
+
+	handle := func() {
+		defer c.inFlight.Done()
+
+		ctx, cancel := requestContext(req)
+		defer cancel()
+		// The requester no longer waits for the reply
+		if ctx.Err() != nil {
+			c.logger.Warnf("Dropping expired request %s.%s", srvc, method)
+			return
+		}
+		ctx = withPartialReplier(ctx, c, req, codec)
+		replyData, replyErr := srvc.handleRequest(ctx, req, method, codec)
+		var panicErr *PanicError
+		if errors.As(replyErr, &panicErr) {
+			c.reportPanic(srvc, method, panicErr)
+		}
+		c.sendRequestReply(req, replyData, codec.ContentType(), replyErr)
+	}
 
+	if srvc.queue == nil {
+		handle()
+		return nil
+	}
+	select {
+	case srvc.queue <- handle:
+	default:
+		c.inFlight.Done()
+		c.sendRequestReply(req, nil, codec.ContentType(), fmt.Errorf("Service %s is busy", srvc))
+	}
 	return nil
 }
 
+// reportPanic logs the panic of a request handler and publishes it in the
+// log.<service>.panic event.
+func (c *Client) reportPanic(srvc *service, method string, panicErr *PanicError) {
+	c.logger.Errorf("Request handler %s.%s panicked: %v\n%s", srvc, method, panicErr.Value, panicErr.Stack)
+	err := c.Log(srvc.Name+".panic", api.HandlerPanicJSON{
+		Service:        srvc.Name,
+		Identification: srvc.Identification,
+		Method:         method,
+		Panic:          fmt.Sprint(panicErr.Value),
+		Stack:          string(panicErr.Stack),
+	})
+	if err != nil {
+		c.logger.Errorf("Could not publish panic: %s", err)
+	}
+}
+
 // TODO(halfr): handle different kind of errors
-func (c *Client) sendRequestReply(req *cellaserv.Request, replyData []byte, replyErr error) {
+func (c *Client) sendRequestReply(req *cellaserv.Request, replyData []byte, contentType string, replyErr error) {
 	msgType := cellaserv.Message_Reply
 	msgContent := &cellaserv.Reply{Id: req.Id, Data: replyData}
+	common.SetContentType(msgContent, contentType)
 
 	if replyErr != nil {
 		// Log error
@@ -186,13 +368,18 @@ func (c *Client) sendRequestReply(req *cellaserv.Request, replyData []byte, repl
 	msgContentBytes, _ := proto.Marshal(msgContent)
 	msg := &cellaserv.Message{Type: msgType, Content: msgContentBytes}
 
-	err := common.SendMessage(c.conn, msg)
+	err := common.SendMessage(c.currentConn(), msg)
 	if err != nil {
 		c.logger.Warnf("Could not send reply: %s", err)
 	}
 }
 
 func (c *Client) handleReply(rep *cellaserv.Reply) error {
+	// Spies only see the final reply
+	if common.IsPartialReply(rep) {
+		return c.dispatchReply(rep)
+	}
+
 	// Dispatch reply to spies
 	hasSpied := false
 	spyPending, ok := c.spyRequestsPending[rep.GetId()]
@@ -206,40 +393,88 @@ func (c *Client) handleReply(rep *cellaserv.Reply) error {
 		delete(c.spyRequestsPending, rep.GetId())
 	}
 
-	// Dispatch reply to known requests
+	err := c.dispatchReply(rep)
+	if err != nil && hasSpied {
+		return nil
+	}
+	return err
+}
+
+// dispatchReply sends the reply to the request waiting for it.
+func (c *Client) dispatchReply(rep *cellaserv.Reply) error {
+	if common.IsPartialReply(rep) {
+		return c.dispatchPartialReply(rep)
+	}
+
+	c.mtx.RLock()
 	replyChan, ok := c.requestsInFlight[rep.GetId()]
+	c.mtx.RUnlock()
 	if !ok {
-		if hasSpied {
-			return nil
-		}
 		return fmt.Errorf("Could not find request matching reply: %s", rep.String())
 	}
-	replyChan <- rep
+	select {
+	case replyChan <- rep:
+	default:
+		return fmt.Errorf("Duplicate reply to request %d", rep.GetId())
+	}
 	return nil
 }
 
+// handlePublish queues the event for all the matching subscribers. It never
+// blocks: events are dropped for subscribers whose queue is full.
 func (c *Client) handlePublish(pub *cellaserv.Publish) {
 	eventName := pub.GetEvent()
 	c.logger.Infof("Received event: %q", eventName)
-	var subscriberToRemove []int
-	c.mtx.Lock()
-	for idx, s := range c.subscribers {
+
+	e := Event{
+		Name:        eventName,
+		Data:        pub.GetData(),
+		ContentType: common.GetContentType(pub),
+		TraceId:     common.GetTraceId(pub),
+	}
+
+	// Queues are closed with the lock held, see Unsubscribe()
+	c.mtx.RLock()
+	for pattern, ring := range c.replayBuffers {
+		if matched, _ := filepath.Match(pattern, eventName); matched {
+			ring.add(e)
+		}
+	}
+	for _, s := range c.subscribers {
 		if matched, _ := filepath.Match(s.eventPattern, eventName); matched {
-			shouldRemove := s.handle(eventName, pub.GetData())
-			if shouldRemove {
-				// Prepend, so that subscriberToRemove is in
-				// reverse index order, this is a required
-				// property for removal algorithm.
-				subscriberToRemove = append([]int{idx}, subscriberToRemove...)
+			select {
+			case s.events <- e:
+			default:
+				c.logger.Warnf("Subscriber queue for %q is full, dropping event %q", s.eventPattern, eventName)
 			}
 		}
 	}
-	for _, idx := range subscriberToRemove {
-		c.subscribers[idx] = c.subscribers[len(c.subscribers)-1]
-		c.subscribers = c.subscribers[:len(c.subscribers)-1]
+	c.mtx.RUnlock()
+}
 
+// runSubscriber calls the subscriber handler for each queued event, until the
+// subscription is removed or the client quits.
+func (c *Client) runSubscriber(s *subscriber) {
+	if s.onDone != nil {
+		defer s.onDone()
+	}
+	for {
+		select {
+		case e, ok := <-s.events:
+			if !ok {
+				return
+			}
+			shouldRemove := s.handle(e)
+			if shouldRemove {
+				if err := c.Unsubscribe(s.id); err != nil {
+					c.logger.Warnf("Could not remove subscriber: %s", err)
+				}
+				return
+			}
+		case <-c.quitCh:
+			return
+		}
 	}
-	c.mtx.Unlock()
 }
 
 func (c *Client) handleMessage(msg *cellaserv.Message) error {
@@ -268,8 +503,16 @@ func (c *Client) handleMessage(msg *cellaserv.Message) error {
 			return fmt.Errorf("Could not unmarshal reply: %s", err)
 		}
 		return c.handleReply(rep)
+	case common.MessagePing:
+		return common.SendMessage(c.currentConn(), &cellaserv.Message{Type: common.MessagePong, Content: msg.Content})
+	case common.MessagePong:
+		// Receiving the pong is enough to keep the connection alive
 	case cellaserv.Message_Subscribe:
 		fallthrough
+	case common.MessageUnsubscribe:
+		fallthrough
+	case common.MessageUnregister:
+		fallthrough
 	case cellaserv.Message_Register:
 		return fmt.Errorf("Client received unsupported message type: %d", msg.Type)
 	default:
@@ -278,10 +521,113 @@ func (c *Client) handleMessage(msg *cellaserv.Message) error {
 	return nil
 }
 
-// Close shuts down the client.
+// readMessages receives the messages from cellaserv until the client quits.
+func (c *Client) readMessages(keepaliveTimeout time.Duration) {
+	for {
+		conn := c.currentConn()
+		if keepaliveTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(keepaliveTimeout))
+		}
+		closed, _, msg, err := common.RecvMessage(conn)
+		if err != nil {
+			select {
+			case <-c.quitCh:
+				// The connection was closed by Close()
+				return
+			default:
+			}
+			if common.IsTimeout(err) {
+				c.logger.Errorf("Connection to cellaserv timed out")
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) {
+				conn.Close()
+				closed = true
+			}
+		}
+		if closed {
+			if c.redial == nil || !c.reconnect(err) {
+				close(c.closeCh)
+				return
+			}
+			continue
+		}
+		if err != nil {
+			c.logger.Errorf("Could not receive message: %s", err)
+			continue
+		}
+		select {
+		case c.msgCh <- msg:
+		case <-c.quitCh:
+			return
+		}
+	}
+}
+
+// keepalive periodically pings cellaserv so that the connection is never idle
+// for longer than the keepalive timeout while cellaserv is reachable.
+func (c *Client) keepalive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := common.SendMessage(c.currentConn(), &cellaserv.Message{Type: common.MessagePing})
+			if err != nil {
+				c.logger.Warnf("Could not send keepalive ping: %s", err)
+			}
+		case <-c.closeCh:
+			return
+		case <-c.quitCh:
+			return
+		}
+	}
+}
+
+// Close shuts down the client. New requests are refused, then Close waits for
+// the requests in flight and the request handlers in progress to complete,
+// at most ClientOpts.CloseTimeout, before closing the connection.
 func (c *Client) Close() {
-	c.quit = true
-	close(c.quitCh)
+	c.mtx.Lock()
+	if c.closing {
+		c.mtx.Unlock()
+		return
+	}
+	c.closing = true
+	c.mtx.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		c.inFlight.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-c.quitCh:
+		// Connection lost, requests in flight are failing
+	case <-time.After(c.closeTimeout):
+		c.logger.Warnf("Closing with requests still in flight")
+	}
+
+	c.signalQuit()
+	c.currentConn().Close()
+}
+
+// signalQuit closes the quit channel.
+func (c *Client) signalQuit() {
+	c.quitOnce.Do(func() { close(c.quitCh) })
+}
+
+// track registers a request or request handler that Close waits for. It
+// returns false if the client is closing.
+func (c *Client) track() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.closing {
+		return false
+	}
+	c.inFlight.Add(1)
+	return true
 }
 
 // Quit returns the receive-only quit channel.
@@ -297,7 +643,20 @@ func (c *Client) RegisterService(s *service) {
 	// Keep a pointer to the service
 	c.services[s.Name][s.Identification] = s
 
-	// Send register message to cellaserv
+	// Start the workers of concurrent services
+	if s.workers > 0 && s.queue == nil {
+		s.queue = make(chan func(), s.queueSize)
+		for i := 0; i < s.workers; i++ {
+			go s.runWorker(c.quitCh)
+		}
+	}
+
+	c.sendRegister(s)
+	c.logger.Infof("Registered service %s", s)
+}
+
+// sendRegister sends the register message of the service to cellaserv.
+func (c *Client) sendRegister(s *service) {
 	msgType := cellaserv.Message_Register
 	msgContent := &cellaserv.Register{
 		Name:           s.Name,
@@ -305,51 +664,185 @@ func (c *Client) RegisterService(s *service) {
 	}
 	msgContentBytes, _ := proto.Marshal(msgContent)
 	msg := &cellaserv.Message{Type: msgType, Content: msgContentBytes}
-	err := common.SendMessage(c.conn, msg)
+	err := common.SendMessage(c.currentConn(), msg)
 	if err != nil {
 		c.logger.Errorf("Could not send message: %s", err)
 	}
+}
 
-	c.logger.Infof("Registered service %s", s)
+// UnregisterService stops handling the requests of the service and removes it
+// from cellaserv. The other services of the client are kept.
+func (c *Client) UnregisterService(s *service) error {
+	idents, ok := c.services[s.Name]
+	if !ok || idents[s.Identification] != s {
+		return fmt.Errorf("Service %s is not registered", s)
+	}
+	delete(idents, s.Identification)
+
+	// Send unregister message to cellaserv
+	msgContent := &cellaserv.Register{
+		Name:           s.Name,
+		Identification: s.Identification,
+	}
+	msgContentBytes, err := proto.Marshal(msgContent)
+	if err != nil {
+		return fmt.Errorf("Could not marshal unregister: %s", err)
+	}
+	msg := &cellaserv.Message{Type: common.MessageUnregister, Content: msgContentBytes}
+	if err := common.SendMessage(c.currentConn(), msg); err != nil {
+		return fmt.Errorf("Could not send message: %s", err)
+	}
+
+	c.logger.Infof("Unregistered service %s", s)
+	return nil
 }
 
-func (c *Client) Publish(event string, data interface{}) {
+// Publish sends an event with data serialized with the codec of the client,
+// JSON by default. Use PublishRaw for data that must be sent as-is.
+func (c *Client) Publish(event string, data interface{}) error {
 	c.logger.Debugf("Publishing %s(%v)", event, data)
 
-	// Serialize request payload
-	dataBytes, err := json.Marshal(data)
+	pub, err := c.newPublish(event, data)
+	if err != nil {
+		return err
+	}
+	return c.invokePublish(pub)
+}
+
+// PublishAck sends an event and waits for cellaserv to acknowledge it
+// according to the mode, so that important events, e.g. emergency stops, are
+// not lost silently. With common.AckFanout, it returns the number of
+// subscribers the event was sent to.
+func (c *Client) PublishAck(event string, data interface{}, mode common.AckMode) (int, error) {
+	if mode == common.AckNone {
+		return 0, c.Publish(event, data)
+	}
+	c.logger.Debugf("Publishing %s(%v) with acknowledgment", event, data)
+
+	if !c.track() {
+		return 0, ErrConnectionClosed
+	}
+	defer c.inFlight.Done()
+
+	pub, err := c.newPublish(event, data)
 	if err != nil {
-		panic(fmt.Sprintf("Could not marshal publish data to JSON: %v", data))
+		return 0, err
 	}
-	c.PublishRaw(event, dataBytes)
+
+	// Acknowledgments are replies, their ids are taken from the request ids
+	id := atomic.AddUint64(&c.currentRequestId, 1)
+	common.SetPublishAck(pub, id, mode)
+
+	replyCh := make(chan *cellaserv.Reply, 1)
+	c.mtx.Lock()
+	c.requestsInFlight[id] = replyCh
+	c.mtx.Unlock()
+	defer func() {
+		c.mtx.Lock()
+		delete(c.requestsInFlight, id)
+		c.mtx.Unlock()
+	}()
+
+	lost := c.connLost()
+	if err := c.invokePublish(pub); err != nil {
+		return 0, err
+	}
+
+	var deadline time.Time
+	if c.requestTimeout > 0 {
+		deadline = time.Now().Add(c.requestTimeout)
+	}
+	rep, err := c.waitReply(replyCh, lost, deadline)
+	if err != nil {
+		return 0, err
+	}
+	var subscribers int
+	if mode == common.AckFanout {
+		if err := json.Unmarshal(rep.GetData(), &subscribers); err != nil {
+			return 0, fmt.Errorf("Could not unmarshal publish acknowledgment: %s", err)
+		}
+	}
+	return subscribers, nil
 }
 
+func (c *Client) newPublish(event string, data interface{}) (*cellaserv.Publish, error) {
+	// Serialize publish payload
+	dataBytes, err := c.codec.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("Could not marshal publish data: %s", err)
+	}
+
+	pub := &cellaserv.Publish{
+		Event: event,
+		Data:  dataBytes,
+	}
+	common.SetContentType(pub, c.codec.ContentType())
+	common.SetTraceId(pub, common.NewTraceId())
+	return pub, nil
+}
+
+// PublishContext is like Publish, with the trace id of ctx, e.g. the context
+// of a request handler, sent along with the event.
+func (c *Client) PublishContext(ctx context.Context, event string, data interface{}) error {
+	c.logger.Debugf("Publishing %s(%v) [trace %s]", event, data, TraceId(ctx))
+
+	pub, err := c.newPublish(event, data)
+	if err != nil {
+		return err
+	}
+	if traceId := TraceId(ctx); traceId != "" {
+		common.SetTraceId(pub, traceId)
+	}
+	return c.invokePublish(pub)
+}
+
+// PublishRaw sends an event with data sent as-is.
 func (c *Client) PublishRaw(event string, data []byte) {
 	// Prepare Publish message
 	pub := &cellaserv.Publish{
 		Event: event,
 		Data:  data,
 	}
+	err := c.invokePublish(pub)
+	if err != nil {
+		c.logger.Errorf("Could not publish %s: %s", event, err)
+	}
+}
+
+func (c *Client) sendPublish(pub *cellaserv.Publish) error {
 	pubBytes, err := proto.Marshal(pub)
 	if err != nil {
-		panic(fmt.Sprintf("Could not marshal publish: %s", err))
+		return fmt.Errorf("Could not marshal publish: %s", err)
 	}
 
 	// Send message
 	msgType := cellaserv.Message_Publish
 	msg := &cellaserv.Message{Type: msgType, Content: pubBytes}
-	err = common.SendMessage(c.conn, msg)
+	return common.SendMessage(c.currentConn(), msg)
+}
+
+// PublishJSON sends an event with data serialized in JSON, whatever the codec
+// of the client.
+func (c *Client) PublishJSON(event string, data interface{}) error {
+	dataBytes, err := JSONCodec.Marshal(data)
 	if err != nil {
-		c.logger.Errorf("Could not send message: %s", err)
+		return fmt.Errorf("Could not marshal publish data: %s", err)
 	}
+	return c.PublishBytes(event, dataBytes)
+}
+
+// PublishBytes sends an event with data sent as-is. Unlike PublishRaw, it
+// returns the error instead of logging it.
+func (c *Client) PublishBytes(event string, data []byte) error {
+	return c.invokePublish(&cellaserv.Publish{Event: event, Data: data})
 }
 
 // Log sends a log message to cellaserv
-func (c *Client) Log(what string, data interface{}) {
-	c.Publish("log."+what, data)
+func (c *Client) Log(what string, data interface{}) error {
+	return c.Publish("log."+what, data)
 }
 
-func (c *Client) Subscribe(eventPattern string, handler subscriberHandler) error {
+func (c *Client) Subscribe(eventPattern string, handler subscriberHandler) (SubscriptionId, error) {
 	// Wraps the handler to always return false, i.e. never remove the subscriber
 	wrapped := func(eventName string, eventData []byte) bool {
 		handler(eventName, eventData)
@@ -358,15 +851,97 @@ func (c *Client) Subscribe(eventPattern string, handler subscriberHandler) error
 	return c.SubscribeUntil(eventPattern, wrapped)
 }
 
-func (c *Client) SubscribeUntil(eventPattern string, handler subscriberUntilHandler) error {
+// SubscribeOnce subscribes to the event pattern for the next matching event
+// only. The subscription is removed once the handler has been called.
+func (c *Client) SubscribeOnce(eventPattern string, handler subscriberHandler) (SubscriptionId, error) {
+	wrapped := func(eventName string, eventData []byte) bool {
+		handler(eventName, eventData)
+		return true
+	}
+	return c.SubscribeUntil(eventPattern, wrapped)
+}
+
+// SubscribeChan subscribes to the event pattern and returns the channel of
+// matching events. The events are queued until the channel is read, and
+// dropped once the queue is full, see ClientOpts.EventQueueSize. The channel
+// is closed when the subscription is cancelled or the client quits.
+func (c *Client) SubscribeChan(eventPattern string) (<-chan Event, func(), error) {
+	ch := make(chan Event)
+	done := make(chan struct{})
+	handler := func(e Event) bool {
+		select {
+		case ch <- e:
+			return false
+		case <-done:
+			return true
+		}
+	}
+
+	id, err := c.subscribe(eventPattern, handler, func() { close(ch) })
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			close(done)
+			if err := c.Unsubscribe(id); err != nil {
+				c.logger.Debugf("Subscription already removed: %s", err)
+			}
+		})
+	}
+	return ch, cancel, nil
+}
+
+// SubscribeUntil subscribes to the event pattern. The subscription is removed
+// when the handler returns true.
+func (c *Client) SubscribeUntil(eventPattern string, handler subscriberUntilHandler) (SubscriptionId, error) {
+	return c.subscribe(eventPattern, func(e Event) bool {
+		return handler(e.Name, e.Data)
+	}, nil)
+}
+
+// subscribe adds a subscriber calling handler, and onDone when it stops.
+func (c *Client) subscribe(eventPattern string, handler func(e Event) bool, onDone func()) (SubscriptionId, error) {
 	// Create and add to subscriber map
+	c.mtx.Lock()
+	c.currentSubscriptionId++
 	s := &subscriber{
+		id:           SubscriptionId(c.currentSubscriptionId),
 		eventPattern: eventPattern,
 		handle:       handler,
+		events:       make(chan Event, c.eventQueueSize),
+		onDone:       onDone,
 	}
-	c.logger.Infof("Subscribing to event pattern: %q", eventPattern)
 	c.subscribers = append(c.subscribers, s)
+	if c.replaySize > 0 {
+		ring, ok := c.replayBuffers[eventPattern]
+		if !ok {
+			ring = newEventRing(c.replaySize)
+			c.replayBuffers[eventPattern] = ring
+		}
+		// Replay the last events before the new ones are queued
+		for _, e := range ring.list() {
+			select {
+			case s.events <- e:
+			default:
+			}
+		}
+	}
+	c.mtx.Unlock()
+	go c.runSubscriber(s)
+	c.logger.Infof("Subscribing to event pattern: %q", eventPattern)
 
+	if err := c.sendSubscribe(eventPattern); err != nil {
+		return 0, err
+	}
+	return s.id, nil
+}
+
+// sendSubscribe sends the subscribe message of the event pattern to
+// cellaserv.
+func (c *Client) sendSubscribe(eventPattern string) error {
 	// Prepare subscribe message
 	msgType := cellaserv.Message_Subscribe
 	sub := &cellaserv.Subscribe{Event: eventPattern}
@@ -378,7 +953,59 @@ func (c *Client) SubscribeUntil(eventPattern string, handler subscriberUntilHand
 	msg := cellaserv.Message{Type: msgType, Content: subBytes}
 
 	// Send subscribe message
-	err = common.SendMessage(c.conn, &msg)
+	err = common.SendMessage(c.currentConn(), &msg)
+	if err != nil {
+		c.logger.Errorf("Could not send message: %s", err)
+	}
+	return nil
+}
+
+// Unsubscribe removes a subscription. The broker is told to stop sending
+// matching events once no other subscription of this client uses the same
+// event pattern.
+func (c *Client) Unsubscribe(id SubscriptionId) error {
+	c.mtx.Lock()
+	var eventPattern string
+	found := false
+	for i, s := range c.subscribers {
+		if s.id == id {
+			eventPattern = s.eventPattern
+			close(s.events)
+			c.subscribers = append(c.subscribers[:i], c.subscribers[i+1:]...)
+			found = true
+			break
+		}
+	}
+	patternInUse := false
+	for _, s := range c.subscribers {
+		if s.eventPattern == eventPattern {
+			patternInUse = true
+			break
+		}
+	}
+	c.mtx.Unlock()
+
+	if !found {
+		return fmt.Errorf("No such subscription: %d", id)
+	}
+	if patternInUse {
+		return nil
+	}
+
+	c.logger.Infof("Unsubscribing from event pattern: %q", eventPattern)
+
+	// Prepare unsubscribe message
+	msgType := common.MessageUnsubscribe
+	sub := &cellaserv.Subscribe{Event: eventPattern}
+	subBytes, err := proto.Marshal(sub)
+	if err != nil {
+		return fmt.Errorf("Could not marshal unsubscribe: %s", err)
+	}
+
+	msg := cellaserv.Message{Type: msgType, Content: subBytes}
+
+	// Send unsubscribe message
+	err = common.SendMessage(c.currentConn(), &msg)
 	if err != nil {
 		c.logger.Errorf("Could not send message: %s", err)
 	}
@@ -395,64 +1022,105 @@ func (c *Client) Spy(serviceName string, serviceIdentification string, handler s
 	}
 	spyIdents[serviceIdentification] = append(spyIdents[serviceIdentification], handler)
 
-	// Create service stub
-	cs := NewServiceStub(c, "cellaserv", "")
-	// Make request
+	c.sendSpy(serviceName, serviceIdentification)
+	return nil
+}
+
+// sendSpy asks cellaserv to forward the requests and replies of the service
+// to this client.
+func (c *Client) sendSpy(serviceName string, serviceIdentification string) {
 	spyArgs := &cs_api.SpyRequest{
 		ServiceName:           serviceName,
 		ServiceIdentification: serviceIdentification,
 		ClientId:              c.ClientId(),
 	}
-	_, err := cs.Request("spy", spyArgs)
+	_, err := c.Cs.Request("spy", spyArgs)
 	if err != nil {
 		c.logger.Warnf("Spy request returned error: %s", err)
 	}
-
-	return nil
 }
 
-func newClient(conn net.Conn, name string) *Client {
-	logName := name
-	if logName == "" {
-		logName = "client"
+func newClient(conn net.Conn, opts ClientOpts) *Client {
+	name := opts.Name
+	logger := opts.Logger
+	if logger == nil {
+		logName := name
+		if logName == "" {
+			logName = "client"
+		}
+		logger = common.NewLogger(logName)
+	}
+
+	eventQueueSize := opts.EventQueueSize
+	if eventQueueSize == 0 {
+		eventQueueSize = defaultEventQueueSize
+	}
+
+	codec := opts.Codec
+	if codec == nil {
+		codec = JSONCodec
+	}
+
+	closeTimeout := opts.CloseTimeout
+	if closeTimeout == 0 {
+		closeTimeout = defaultCloseTimeout
+	}
+
+	keepaliveTimeout := opts.KeepaliveTimeout
+	if keepaliveTimeout == 0 {
+		keepaliveTimeout = 3 * opts.KeepaliveInterval
 	}
 
 	c := &Client{
-		logger:             common.NewLogger(logName),
+		logger:             logger,
+		eventQueueSize:     eventQueueSize,
+		closeTimeout:       closeTimeout,
+		requestTimeout:     opts.RequestTimeout,
+		replaySize:         opts.EventReplaySize,
+		replayBuffers:      make(map[string]*eventRing),
+		codec:              codec,
 		conn:               conn,
 		services:           make(map[string]map[string]*service),
 		requestsInFlight:   make(map[uint64]chan *cellaserv.Reply),
+		streams:            make(map[*cellaserv.Request]PartialReplyHandler),
+		partialReplies:     make(map[uint64]PartialReplyHandler),
 		spies:              make(map[string]map[string][]spyHandler),
 		spyRequestsPending: make(map[uint64]*spyPendingRequest),
 		currentRequestId:   rand.Uint64(),
 		msgCh:              make(chan *cellaserv.Message),
+		connLostCh:         make(chan struct{}),
+		redial:             opts.redial,
+		reconnectInterval:  opts.ReconnectInterval,
+		onDisconnect:       opts.OnDisconnect,
+		onReconnect:        opts.OnReconnect,
+		name:               name,
 		closeCh:            make(chan struct{}),
 		quitCh:             make(chan struct{}),
 	}
-	// Initialize the cellaserv stub
-	c.Cs = NewServiceStub(c, "cellaserv", "")
+	// Initialize the cellaserv stub, the cellaserv service only speaks JSON
+	c.Cs = NewServiceStub(c, "cellaserv", "", WithCodec(JSONCodec))
+
+	if opts.PublishRateLimit != nil && opts.PublishRateLimit.Rate > 0 {
+		limiter := newPublishLimiter(*opts.PublishRateLimit, logger)
+		c.publishInterceptors = append(c.publishInterceptors, limiter.interceptor)
+	}
 
 	// Receive incoming messages
-	go func() {
-		for {
-			closed, _, msg, err := common.RecvMessage(c.conn)
-			if closed {
-				close(c.closeCh)
-				break
-			}
-			if err != nil {
-				c.logger.Errorf("Could not receive message: %s", err)
-				continue
-			}
-			c.msgCh <- msg
-		}
-	}()
+	go c.readMessages(keepaliveTimeout)
+
+	if opts.KeepaliveInterval > 0 {
+		go c.keepalive(opts.KeepaliveInterval)
+	}
 
 	// Setup name, if given
 	if name != "" {
 		go c.Cs.Request("name_client", api.NameClientRequest{Name: name})
 	}
 
+	if opts.OnConnect != nil {
+		go opts.OnConnect(c)
+	}
+
 	// Handle message or quit
 	go func() {
 	Loop:
@@ -464,9 +1132,7 @@ func newClient(conn net.Conn, name string) *Client {
 					c.logger.Errorf("Could not handle incoming message: %s", err)
 				}
 			case <-c.closeCh:
-				if !c.quit {
-					close(c.quitCh)
-				}
+				c.signalQuit()
 				break Loop
 			case <-c.quitCh:
 				break Loop
@@ -478,12 +1144,73 @@ func newClient(conn net.Conn, name string) *Client {
 }
 
 type ClientOpts struct {
-	// Address of the cellaserv server
+	// Address of the cellaserv server, either host:port, tcp://host:port or
+	// unix:///path/to/socket
 	CellaservAddr string
 	// Name sent to cellaserv to describe the client
 	Name string
+	// Dial connects to cellaserv instead of CellaservAddr, e.g.
+	// broker.DialLocal to connect to a broker running in the same process.
+	Dial func() (net.Conn, error)
+	// TLS configuration used to connect to a TLS-enabled cellaserv, nil to
+	// connect without TLS. Set its Certificates for mutual authentication.
+	TLSConfig *tls.Config
+	// Logger of the client, defaults to a logrus logger named after the
+	// client. See the common package for adapters to other loggers.
+	Logger common.Logger
 	// Address where the internal web service will listen, empty to disable web server
 	WebListenAddress string
+	// Number of events that can wait to be handled by each subscriber.
+	// Events received while the queue is full are dropped. Defaults to 64.
+	EventQueueSize int
+	// Maximum duration to wait for the reply to a request, after which
+	// ErrTimeout is returned. The deadline is sent with the request. Zero
+	// waits until cellaserv times out the request.
+	RequestTimeout time.Duration
+	// Maximum duration Close waits for the requests in flight and the
+	// request handlers in progress. Defaults to 5 seconds.
+	CloseTimeout time.Duration
+	// Number of events kept for each subscribed event pattern. New
+	// subscriptions to the pattern first receive these events, so that
+	// subscribers attached late do not miss the last state transitions.
+	// Zero disables event replay.
+	EventReplaySize int
+	// Limits the rate of the events published with the same name, so that
+	// a buggy loop cannot saturate cellaserv. Nil disables the limit.
+	PublishRateLimit *PublishRateLimit
+	// Codec used to serialize the data of requests, replies and publishes.
+	// Defaults to JSONCodec.
+	Codec Codec
+	// Number of connections to cellaserv used to send requests, so that
+	// small requests are not delayed by large replies. Defaults to 1, the
+	// connection used for services and subscriptions.
+	RequestConnections int
+	// Interval between keepalive pings sent to cellaserv, zero to disable
+	// keepalive.
+	KeepaliveInterval time.Duration
+	// The connection is considered dead, and the client closed, when nothing
+	// is received from cellaserv during this duration. Defaults to three
+	// times KeepaliveInterval.
+	KeepaliveTimeout time.Duration
+	// Interval between the attempts to reconnect to cellaserv after the
+	// connection is lost. Once reconnected, the client name, services,
+	// subscriptions and spies are restored, while the requests that were in
+	// flight fail with ErrConnectionClosed. Zero disables reconnection: the
+	// client quits when the connection is lost.
+	ReconnectInterval time.Duration
+	// Called in its own goroutine once connected to cellaserv.
+	OnConnect func(c *Client)
+	// Called when the connection to cellaserv is lost, with the cause of the
+	// disconnection if known. It must not send requests.
+	OnDisconnect func(c *Client, err error)
+	// Called in its own goroutine once reconnected to cellaserv and the
+	// client state restored, e.g. to publish the state of the services
+	// again.
+	OnReconnect func(c *Client)
+
+	// Dials a new connection to cellaserv, set by NewClient when
+	// reconnection is enabled
+	redial func() (net.Conn, error)
 }
 
 // NewConnection returns a Client instance connected to cellaserv or panics
@@ -502,13 +1229,34 @@ func NewClient(opts ClientOpts) *Client {
 		csAddr = fmt.Sprintf("%s:%s", csHost, csPort)
 	}
 
+	dialFn := opts.Dial
+	if dialFn == nil {
+		dialFn = func() (net.Conn, error) {
+			return dial(csAddr, opts.TLSConfig)
+		}
+	}
+	if opts.ReconnectInterval > 0 {
+		opts.redial = dialFn
+	}
+
 	// Connect
-	conn, err := net.Dial("tcp", csAddr)
+	conn, err := dialFn()
 	if err != nil {
 		panic(fmt.Errorf("Could not connect to cellaserv: %s", err))
 	}
 
-	return newClient(conn, opts.Name)
+	c := newClient(conn, opts)
+
+	// Open the additional request connections
+	for i := 1; i < opts.RequestConnections; i++ {
+		conn, err := dialFn()
+		if err != nil {
+			panic(fmt.Errorf("Could not connect to cellaserv: %s", err))
+		}
+		c.addRequestConn(conn)
+	}
+
+	return c
 }
 
 func init() {
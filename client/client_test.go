@@ -2,19 +2,24 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net"
+	"sync"
 	"testing"
 	"time"
 
 	cellaserv "github.com/evolutek/cellaserv3-protobuf"
 	"github.com/evolutek/cellaserv3/common"
+	"github.com/evolutek/cellaserv3/testutil"
 	"github.com/golang/protobuf/proto"
 )
 
 func TestNewClient(t *testing.T) {
 	_, client := net.Pipe()
-	c := newClient(client, "test")
+	c := newClient(client, ClientOpts{Name: "test"})
 	c.Close()
 }
 
@@ -32,13 +37,13 @@ func TestRegisterService(t *testing.T) {
 	}()
 
 	// Connect to cellaserv
-	conn := newClient(client, "") // no name
+	conn := newClient(client, ClientOpts{}) // no name
 	// TODO(halfr): test with a name
 
 	// Prepare service for registration
 	date := conn.NewService("date", "")
 	// Handle "time" request
-	date.HandleRequestFunc("time", func(_ *cellaserv.Request) (interface{}, error) {
+	date.HandleRequestFunc("time", func(context.Context, *cellaserv.Request) (interface{}, error) {
 		return time.Now(), nil
 	})
 	// Handle "killall" event
@@ -90,7 +95,7 @@ func TestServiceStubRequest(t *testing.T) {
 		common.SendMessage(server, replyMsg)
 	}()
 
-	c := newClient(client, "test")
+	c := newClient(client, ClientOpts{Name: "test"})
 	// Create date service stub
 	date := NewServiceStub(c, "date", "")
 	// Request date.time()
@@ -147,7 +152,456 @@ func TestPublish(t *testing.T) {
 		}
 	}()
 
-	c := newClient(client, "test")
+	c := newClient(client, ClientOpts{Name: "test"})
 	c.Publish(publishEvent, publishData)
 	<-done
 }
+
+func TestUnsubscribe(t *testing.T) {
+	server, client := net.Pipe()
+
+	msgTypes := make(chan cellaserv.Message_MessageType, 4)
+	go func() {
+		for {
+			closed, _, msg, err := common.RecvMessage(server)
+			if closed || err != nil {
+				return
+			}
+			msgTypes <- msg.GetType()
+		}
+	}()
+
+	c := newClient(client, ClientOpts{})
+	defer c.Close()
+
+	handler := func(string, []byte) {}
+	first, err := c.Subscribe("foo", handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.Subscribe("foo", handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The pattern is still used by the second subscription
+	if err := c.Unsubscribe(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Unsubscribe(second); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Unsubscribe(second); err == nil {
+		t.Fatal("Did not return error on unknown subscription")
+	}
+
+	expected := []cellaserv.Message_MessageType{
+		cellaserv.Message_Subscribe,
+		cellaserv.Message_Subscribe,
+		common.MessageUnsubscribe,
+	}
+	for _, msgType := range expected {
+		select {
+		case received := <-msgTypes:
+			if received != msgType {
+				t.Fatalf("Invalid message type, should be %s, is: %s", msgType, received)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Did not receive %s message", msgType)
+		}
+	}
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	server, client := net.Pipe()
+
+	// Discard messages sent by the client
+	go func() {
+		for {
+			closed, _, _, err := common.RecvMessage(server)
+			if closed || err != nil {
+				return
+			}
+		}
+	}()
+
+	c := newClient(client, ClientOpts{})
+	defer c.Close()
+
+	unblock := make(chan struct{})
+	defer close(unblock)
+	c.Subscribe("slow", func(string, []byte) {
+		<-unblock
+	})
+	fastReceived := make(chan struct{})
+	c.Subscribe("fast", func(string, []byte) {
+		close(fastReceived)
+	})
+
+	for _, event := range []string{"slow", "slow", "fast"} {
+		pubBytes, _ := proto.Marshal(&cellaserv.Publish{Event: event})
+		msg := &cellaserv.Message{Type: cellaserv.Message_Publish, Content: pubBytes}
+		if err := common.SendMessage(server, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The slow subscriber does not prevent the other from being called
+	select {
+	case <-fastReceived:
+	case <-time.After(time.Second):
+		t.Fatal("Fast subscriber was not called")
+	}
+}
+
+func TestSubscribeOnce(t *testing.T) {
+	server, client := net.Pipe()
+
+	msgTypes := make(chan cellaserv.Message_MessageType, 4)
+	go func() {
+		for {
+			closed, _, msg, err := common.RecvMessage(server)
+			if closed || err != nil {
+				return
+			}
+			msgTypes <- msg.GetType()
+		}
+	}()
+
+	c := newClient(client, ClientOpts{})
+	defer c.Close()
+
+	calls := make(chan string, 2)
+	_, err := c.SubscribeOnce("match.*", func(name string, _ []byte) {
+		calls <- name
+	})
+	testutil.Ok(t, err)
+
+	for _, event := range []string{"match.start", "match.end"} {
+		pubBytes, _ := proto.Marshal(&cellaserv.Publish{Event: event})
+		msg := &cellaserv.Message{Type: cellaserv.Message_Publish, Content: pubBytes}
+		testutil.Ok(t, common.SendMessage(server, msg))
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// Only the first event is handled and the broker is told to unsubscribe
+	testutil.Equals(t, 1, len(calls))
+	testutil.Equals(t, "match.start", <-calls)
+	testutil.Equals(t, cellaserv.Message_Subscribe, <-msgTypes)
+	testutil.Equals(t, common.MessageUnsubscribe, <-msgTypes)
+}
+
+func TestSubscribeChan(t *testing.T) {
+	server, client := net.Pipe()
+
+	// Discard messages sent by the client
+	go func() {
+		for {
+			closed, _, _, err := common.RecvMessage(server)
+			if closed || err != nil {
+				return
+			}
+		}
+	}()
+
+	c := newClient(client, ClientOpts{})
+	defer c.Close()
+
+	events, cancel, err := c.SubscribeChan("foo")
+	testutil.Ok(t, err)
+
+	for _, data := range []string{"1", "2"} {
+		pubBytes, _ := proto.Marshal(&cellaserv.Publish{Event: "foo", Data: []byte(data)})
+		msg := &cellaserv.Message{Type: cellaserv.Message_Publish, Content: pubBytes}
+		testutil.Ok(t, common.SendMessage(server, msg))
+	}
+
+	for _, data := range []string{"1", "2"} {
+		select {
+		case e := <-events:
+			testutil.Equals(t, Event{Name: "foo", Data: []byte(data)}, e)
+		case <-time.After(time.Second):
+			t.Fatal("Event not received")
+		}
+	}
+
+	// The channel is closed once cancelled
+	cancel()
+	select {
+	case _, ok := <-events:
+		testutil.Assert(t, !ok, "channel was not closed")
+	case <-time.After(time.Second):
+		t.Fatal("Channel not closed")
+	}
+	cancel()
+}
+
+func TestInvalidJSONPayload(t *testing.T) {
+	_, client := net.Pipe()
+	c := newClient(client, ClientOpts{})
+	defer c.Close()
+
+	// Channels cannot be serialized as JSON
+	if err := c.Publish("foo", make(chan int)); err == nil {
+		t.Fatal("Did not return error on invalid publish data")
+	}
+	if _, err := NewServiceStub(c, "foo", "").Request("bar", make(chan int)); err == nil {
+		t.Fatal("Did not return error on invalid request data")
+	}
+}
+
+func TestRawReply(t *testing.T) {
+	_, client := net.Pipe()
+	c := newClient(client, ClientOpts{})
+	defer c.Close()
+
+	frame := []byte{0, 1, 2, 255}
+	s := c.NewService("lidar", "")
+	s.HandleRequestFunc("scan", func(context.Context, *cellaserv.Request) (interface{}, error) {
+		return RawData(frame), nil
+	})
+
+	replyData, err := s.handleRequest(context.Background(), &cellaserv.Request{Method: "scan"}, "scan", JSONCodec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(replyData, frame) {
+		t.Fatalf("Raw reply data was modified: %v", replyData)
+	}
+}
+
+func TestKeepaliveTimeout(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+
+	// The server never answers pings
+	pings := make(chan struct{}, 16)
+	go func() {
+		for {
+			closed, _, msg, err := common.RecvMessage(server)
+			if closed || err != nil {
+				return
+			}
+			if msg.GetType() == common.MessagePing {
+				pings <- struct{}{}
+			}
+		}
+	}()
+
+	c := newClient(client, ClientOpts{
+		KeepaliveInterval: 10 * time.Millisecond,
+		KeepaliveTimeout:  50 * time.Millisecond,
+	})
+
+	select {
+	case <-pings:
+	case <-time.After(time.Second):
+		t.Fatal("No keepalive ping sent")
+	}
+
+	select {
+	case <-c.Quit():
+	case <-time.After(time.Second):
+		t.Fatal("Client did not detect the dead connection")
+	}
+}
+
+func TestRequestConnections(t *testing.T) {
+	withTestBroker(t, ":4201", func(opts ClientOpts) {
+		srvc := NewClient(opts)
+		defer srvc.Close()
+		s := srvc.NewService("echo", "")
+		s.HandleRequestFunc("echo", func(ctx context.Context, req *cellaserv.Request) (interface{}, error) {
+			return RawData(req.Data), nil
+		})
+		srvc.RegisterService(s)
+		time.Sleep(50 * time.Millisecond)
+
+		opts.RequestConnections = 3
+		c := NewClient(opts)
+		defer c.Close()
+		testutil.Equals(t, 2, len(c.requestConns))
+
+		stub := NewServiceStub(c, "echo", "")
+		var wg sync.WaitGroup
+		for i := 0; i < 12; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				data := []byte(fmt.Sprint(i))
+				resp, err := stub.RequestRaw("echo", data)
+				if err != nil {
+					t.Errorf("Request failed: %s", err)
+					return
+				}
+				if string(resp) != string(data) {
+					t.Errorf("Got reply %q, expected %q", resp, data)
+				}
+			}(i)
+		}
+		wg.Wait()
+	})
+}
+
+func TestCloseDrainsRequests(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+
+	replies := make(chan *cellaserv.Reply, 1)
+	go func() {
+		for {
+			closed, _, msg, err := common.RecvMessage(server)
+			if closed || err != nil {
+				return
+			}
+			if msg.GetType() != cellaserv.Message_Reply {
+				continue
+			}
+			rep := &cellaserv.Reply{}
+			if err := proto.Unmarshal(msg.GetContent(), rep); err != nil {
+				t.Error(err)
+				return
+			}
+			replies <- rep
+		}
+	}()
+
+	c := newClient(client, ClientOpts{})
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s := c.NewService("slow", "")
+	s.HandleRequestFunc("wait", func(context.Context, *cellaserv.Request) (interface{}, error) {
+		close(started)
+		<-release
+		return "done", nil
+	})
+	c.RegisterService(s)
+
+	_, err := server.Write(testutil.MakeMessageRequest(t, "slow", "", "wait", nil))
+	testutil.Ok(t, err)
+	<-started
+
+	closed := make(chan struct{})
+	go func() {
+		c.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+		t.Fatal("Close returned before the request handler completed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case rep := <-replies:
+		testutil.Equals(t, `"done"`, string(rep.Data))
+	case <-time.After(time.Second):
+		t.Fatal("No reply sent for the request in progress")
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close did not return")
+	}
+
+	_, err = NewServiceStub(c, "date", "").Request("time", nil)
+	testutil.Equals(t, ErrConnectionClosed, err)
+}
+
+func TestRequestTimeout(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+
+	// The server never replies
+	deadlines := make(chan time.Time, 1)
+	go func() {
+		for {
+			closed, _, msg, err := common.RecvMessage(server)
+			if closed || err != nil {
+				return
+			}
+			req := &cellaserv.Request{}
+			if err := proto.Unmarshal(msg.GetContent(), req); err != nil {
+				t.Error(err)
+				return
+			}
+			deadline, _ := common.GetDeadline(req)
+			deadlines <- deadline
+		}
+	}()
+
+	c := newClient(client, ClientOpts{RequestTimeout: 50 * time.Millisecond})
+	defer c.Close()
+
+	start := time.Now()
+	_, err := NewServiceStub(c, "date", "").Request("time", nil)
+	testutil.Assert(t, errors.Is(err, ErrTimeout), "Expected ErrTimeout, got: %v", err)
+	deadline := <-deadlines
+	testutil.Assert(t, deadline.After(start), "Deadline not sent with the request: %s", deadline)
+
+	// Timeouts reported by cellaserv are also ErrTimeout
+	replyErr := &ReplyError{Type: cellaserv.Reply_Error_Timeout}
+	testutil.Assert(t, errors.Is(replyErr, ErrTimeout), "Reply timeout is not ErrTimeout")
+	replyErr = &ReplyError{Type: cellaserv.Reply_Error_Custom}
+	testutil.Assert(t, !errors.Is(replyErr, ErrTimeout), "Custom reply error is ErrTimeout")
+}
+
+func TestPublishAck(t *testing.T) {
+	withTestBroker(t, ":4201", func(opts ClientOpts) {
+		sub := NewClient(opts)
+		defer sub.Close()
+		events, cancel, err := sub.SubscribeChan("emergency_stop")
+		testutil.Ok(t, err)
+		defer cancel()
+		time.Sleep(50 * time.Millisecond)
+
+		c := NewClient(opts)
+		defer c.Close()
+
+		n, err := c.PublishAck("emergency_stop", nil, common.AckReceipt)
+		testutil.Ok(t, err)
+		testutil.Equals(t, 0, n)
+
+		n, err = c.PublishAck("emergency_stop", nil, common.AckFanout)
+		testutil.Ok(t, err)
+		testutil.Equals(t, 1, n)
+
+		for i := 0; i < 2; i++ {
+			select {
+			case <-events:
+			case <-time.After(time.Second):
+				t.Fatal("Event not received")
+			}
+		}
+	})
+}
+
+func TestRequestPriority(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+
+	priorities := make(chan common.Priority, 1)
+	go func() {
+		closed, _, msg, err := common.RecvMessage(server)
+		if closed || err != nil {
+			return
+		}
+		req := &cellaserv.Request{}
+		if err := proto.Unmarshal(msg.GetContent(), req); err != nil {
+			t.Error(err)
+			return
+		}
+		priorities <- common.GetPriority(req)
+		server.Write(testutil.MakeMessageReply(t, req.GetId(), nil))
+	}()
+
+	c := newClient(client, ClientOpts{})
+	defer c.Close()
+
+	stub := NewServiceStub(c, "trajman", "", WithPriority(common.PriorityHigh))
+	_, err := stub.RequestNoData("stop")
+	testutil.Ok(t, err)
+	testutil.Equals(t, common.PriorityHigh, <-priorities)
+}
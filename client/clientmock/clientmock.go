@@ -0,0 +1,310 @@
+// Package clientmock provides a mock of the cellaserv client, to unit test
+// services without a running broker.
+//
+//	m := clientmock.New()
+//	m.ExpectRequest("trajman", "", "get_position").Return(Position{X: 1})
+//	robot := NewRobot(m) // takes a client.Interface
+//	m.EmitEvent("start", nil)
+//	m.AssertExpectations(t)
+package clientmock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/evolutek/cellaserv3/client"
+)
+
+// Expectation is an expected request and its programmed reply.
+type Expectation struct {
+	service        string
+	identification string
+	method         string
+
+	reply []byte
+	err   error
+	// Number of expected calls, zero for any number of calls
+	times int
+	calls int
+}
+
+// Return sets the reply data of the request, serialized in JSON.
+func (e *Expectation) Return(data interface{}) *Expectation {
+	reply, err := json.Marshal(data)
+	if err != nil {
+		panic(fmt.Sprintf("Could not marshal reply: %s", err))
+	}
+	e.reply = reply
+	return e
+}
+
+// ReturnRaw sets the reply data of the request, sent as-is.
+func (e *Expectation) ReturnRaw(data []byte) *Expectation {
+	e.reply = data
+	return e
+}
+
+// ReturnError makes the request fail with err, e.g. a *client.ReplyError.
+func (e *Expectation) ReturnError(err error) *Expectation {
+	e.err = err
+	return e
+}
+
+// Times sets the number of times the request is expected. By default the
+// request is expected at least once.
+func (e *Expectation) Times(n int) *Expectation {
+	e.times = n
+	return e
+}
+
+func (e *Expectation) String() string {
+	return fmt.Sprintf("%s[%s].%s", e.service, e.identification, e.method)
+}
+
+// Request is a request received by the mock.
+type Request struct {
+	Service        string
+	Identification string
+	Method         string
+	Data           []byte
+}
+
+type subscriber struct {
+	id           client.SubscriptionId
+	eventPattern string
+	handle       func(eventName string, eventData []byte) bool
+}
+
+// Client is a mock implementation of client.Interface.
+type Client struct {
+	mtx          sync.Mutex
+	expectations []*Expectation
+	requests     []Request
+	published    []client.Event
+	subscribers  []*subscriber
+	nextId       client.SubscriptionId
+	quitCh       chan struct{}
+	quitOnce     sync.Once
+}
+
+var _ client.Interface = (*Client)(nil)
+
+// New returns a mock client without expectations.
+func New() *Client {
+	return &Client{quitCh: make(chan struct{})}
+}
+
+// ExpectRequest adds an expected request. Requests without a matching
+// expectation fail.
+func (m *Client) ExpectRequest(service string, identification string, method string) *Expectation {
+	e := &Expectation{service: service, identification: identification, method: method}
+	m.mtx.Lock()
+	m.expectations = append(m.expectations, e)
+	m.mtx.Unlock()
+	return e
+}
+
+// EmitEvent sends an event, with data serialized in JSON, to the matching
+// subscribers. The handlers are called before EmitEvent returns.
+func (m *Client) EmitEvent(event string, data interface{}) error {
+	dataBytes, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("Could not marshal event data: %s", err)
+	}
+	m.EmitEventRaw(event, dataBytes)
+	return nil
+}
+
+// EmitEventRaw sends an event with data sent as-is to the matching
+// subscribers.
+func (m *Client) EmitEventRaw(event string, data []byte) {
+	m.mtx.Lock()
+	var matching []*subscriber
+	for _, s := range m.subscribers {
+		if matched, _ := filepath.Match(s.eventPattern, event); matched {
+			matching = append(matching, s)
+		}
+	}
+	m.mtx.Unlock()
+
+	for _, s := range matching {
+		if s.handle(event, data) {
+			m.Unsubscribe(s.id)
+		}
+	}
+}
+
+// Published returns the events published through the mock.
+func (m *Client) Published() []client.Event {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return append([]client.Event(nil), m.published...)
+}
+
+// Requests returns the requests sent through the mock.
+func (m *Client) Requests() []Request {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return append([]Request(nil), m.requests...)
+}
+
+// AssertExpectations fails the test if an expected request was not sent the
+// expected number of times.
+func (m *Client) AssertExpectations(t testing.TB) {
+	t.Helper()
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	for _, e := range m.expectations {
+		if e.times == 0 && e.calls == 0 {
+			t.Errorf("Expected request %s was not sent", e)
+		} else if e.times > 0 && e.calls != e.times {
+			t.Errorf("Expected request %s %d times, sent %d times", e, e.times, e.calls)
+		}
+	}
+}
+
+func (m *Client) Publish(event string, data interface{}) error {
+	dataBytes, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("Could not marshal publish data: %s", err)
+	}
+	m.PublishRaw(event, dataBytes)
+	return nil
+}
+
+func (m *Client) PublishContext(ctx context.Context, event string, data interface{}) error {
+	dataBytes, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("Could not marshal publish data: %s", err)
+	}
+	m.mtx.Lock()
+	m.published = append(m.published, client.Event{Name: event, Data: dataBytes, TraceId: client.TraceId(ctx)})
+	m.mtx.Unlock()
+	return nil
+}
+
+func (m *Client) PublishRaw(event string, data []byte) {
+	m.mtx.Lock()
+	m.published = append(m.published, client.Event{Name: event, Data: data})
+	m.mtx.Unlock()
+}
+
+func (m *Client) Subscribe(eventPattern string, handler func(eventName string, eventData []byte)) (client.SubscriptionId, error) {
+	return m.SubscribeUntil(eventPattern, func(eventName string, eventData []byte) bool {
+		handler(eventName, eventData)
+		return false
+	})
+}
+
+func (m *Client) SubscribeUntil(eventPattern string, handler func(eventName string, eventData []byte) bool) (client.SubscriptionId, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.nextId++
+	m.subscribers = append(m.subscribers, &subscriber{
+		id:           m.nextId,
+		eventPattern: eventPattern,
+		handle:       handler,
+	})
+	return m.nextId, nil
+}
+
+func (m *Client) Unsubscribe(id client.SubscriptionId) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	for i, s := range m.subscribers {
+		if s.id == id {
+			m.subscribers = append(m.subscribers[:i], m.subscribers[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("No such subscription: %d", id)
+}
+
+// Stub returns a stub sending its requests to the mock. Stub options are
+// ignored.
+func (m *Client) Stub(name string, identification string, opts ...client.StubOption) client.Stub {
+	return &stub{mock: m, name: name, identification: identification}
+}
+
+func (m *Client) Quit() <-chan struct{} {
+	return m.quitCh
+}
+
+func (m *Client) Close() {
+	m.quitOnce.Do(func() { close(m.quitCh) })
+}
+
+// request records the request and returns the reply of the first matching
+// expectation not yet exhausted.
+func (m *Client) request(req Request) ([]byte, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.requests = append(m.requests, req)
+	for _, e := range m.expectations {
+		if e.service != req.Service || e.identification != req.Identification || e.method != req.Method {
+			continue
+		}
+		if e.times > 0 && e.calls >= e.times {
+			continue
+		}
+		e.calls++
+		return e.reply, e.err
+	}
+	return nil, fmt.Errorf("Unexpected request %s[%s].%s", req.Service, req.Identification, req.Method)
+}
+
+type stub struct {
+	mock           *Client
+	name           string
+	identification string
+}
+
+func (s *stub) Request(method string, data interface{}) ([]byte, error) {
+	dataBytes, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("Could not marshal request data: %s", err)
+	}
+	return s.RequestRaw(method, dataBytes)
+}
+
+func (s *stub) RequestNoData(method string) ([]byte, error) {
+	return s.RequestRaw(method, nil)
+}
+
+func (s *stub) RequestRaw(method string, data []byte) ([]byte, error) {
+	return s.mock.request(Request{
+		Service:        s.name,
+		Identification: s.identification,
+		Method:         method,
+		Data:           data,
+	})
+}
+
+func (s *stub) Call(method string, args interface{}, reply interface{}) error {
+	replyData, err := s.Request(method, args)
+	if err != nil {
+		return err
+	}
+	if reply == nil || len(replyData) == 0 {
+		return nil
+	}
+	return json.Unmarshal(replyData, reply)
+}
+
+func (s *stub) RequestContext(ctx context.Context, method string, data interface{}) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.Request(method, data)
+}
+
+func (s *stub) CallContext(ctx context.Context, method string, args interface{}, reply interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Call(method, args, reply)
+}
@@ -0,0 +1,46 @@
+package clientmock
+
+import (
+	"testing"
+
+	"github.com/evolutek/cellaserv3/client"
+	"github.com/evolutek/cellaserv3/testutil"
+)
+
+type position struct {
+	X, Y float64
+}
+
+// robot is the logic under test, using a client.Interface
+type robot struct {
+	c        client.Interface
+	position position
+}
+
+func (r *robot) start() error {
+	_, err := r.c.Subscribe("match.*", func(eventName string, eventData []byte) {
+		r.c.Stub("trajman", "", client.WithCodec(client.JSONCodec)).Call("get_position", nil, &r.position)
+		r.c.Publish("robot.started", r.position)
+	})
+	return err
+}
+
+func TestMock(t *testing.T) {
+	m := New()
+	m.ExpectRequest("trajman", "", "get_position").Return(position{X: 1, Y: 2}).Times(1)
+
+	r := &robot{c: m}
+	testutil.Ok(t, r.start())
+	testutil.Ok(t, m.EmitEvent("match.start", nil))
+	// Not matching the subscription
+	testutil.Ok(t, m.EmitEvent("log.start", nil))
+
+	testutil.Equals(t, position{X: 1, Y: 2}, r.position)
+	testutil.Equals(t, []client.Event{{Name: "robot.started", Data: []byte(`{"X":1,"Y":2}`)}}, m.Published())
+	m.AssertExpectations(t)
+
+	// The expectation is exhausted
+	_, err := m.Stub("trajman", "").RequestNoData("get_position")
+	testutil.NotOk(t, err, "Unexpected request succeeded")
+	testutil.Equals(t, 2, len(m.Requests()))
+}
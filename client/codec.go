@@ -0,0 +1,115 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	cellaserv "github.com/evolutek/cellaserv3-protobuf"
+	"github.com/evolutek/cellaserv3/common"
+	"github.com/golang/protobuf/proto"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec serializes the data of requests, replies and publishes. The content
+// type of the codec is sent along with the data so that the receiver can
+// select the same codec.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+	// ContentType identifies the codec, e.g. "application/msgpack". It is
+	// empty for JSON, the default codec of cellaserv.
+	ContentType() string
+}
+
+var (
+	// JSONCodec is the default codec, understood by all cellaserv clients.
+	JSONCodec Codec = jsonCodec{}
+	// MsgpackCodec serializes data with MessagePack.
+	MsgpackCodec Codec = msgpackCodec{}
+	// ProtobufCodec serializes protobuf messages.
+	ProtobufCodec Codec = protobufCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (jsonCodec) ContentType() string                        { return "" }
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error)      { return msgpack.Marshal(v) }
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error { return msgpack.Unmarshal(data, v) }
+func (msgpackCodec) ContentType() string                        { return "application/msgpack" }
+
+type protobufCodec struct{}
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("Not a protobuf message: %T", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("Not a protobuf message: %T", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+func (protobufCodec) ContentType() string { return "application/protobuf" }
+
+var (
+	codecsMtx sync.RWMutex
+	codecs    = map[string]Codec{
+		JSONCodec.ContentType():     JSONCodec,
+		MsgpackCodec.ContentType():  MsgpackCodec,
+		ProtobufCodec.ContentType(): ProtobufCodec,
+	}
+)
+
+// RegisterCodec makes a codec available to decode received data with its
+// content type.
+func RegisterCodec(codec Codec) {
+	codecsMtx.Lock()
+	codecs[codec.ContentType()] = codec
+	codecsMtx.Unlock()
+}
+
+// codecFor returns the codec of the message data, or false if the content
+// type of the message is unknown.
+func codecFor(m proto.Message) (Codec, bool) {
+	return codecForContentType(common.GetContentType(m))
+}
+
+// codecForContentType returns the registered codec of the content type.
+func codecForContentType(contentType string) (Codec, bool) {
+	codecsMtx.RLock()
+	defer codecsMtx.RUnlock()
+	codec, ok := codecs[contentType]
+	return codec, ok
+}
+
+// UnmarshalRequest decodes the data of a request with the codec selected by
+// the requester.
+func UnmarshalRequest(req *cellaserv.Request, v interface{}) error {
+	codec, ok := codecFor(req)
+	if !ok {
+		return fmt.Errorf("Unknown request content type: %q", common.GetContentType(req))
+	}
+	return codec.Unmarshal(req.Data, v)
+}
+
+// UnmarshalPublish decodes the data of an event with the codec selected by the
+// publisher.
+func UnmarshalPublish(pub *cellaserv.Publish, v interface{}) error {
+	codec, ok := codecFor(pub)
+	if !ok {
+		return fmt.Errorf("Unknown event content type: %q", common.GetContentType(pub))
+	}
+	return codec.Unmarshal(pub.Data, v)
+}
@@ -0,0 +1,58 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cellaserv "github.com/evolutek/cellaserv3-protobuf"
+	"github.com/evolutek/cellaserv3/testutil"
+)
+
+type position struct {
+	X float64 `json:"x" msgpack:"x"`
+	Y float64 `json:"y" msgpack:"y"`
+}
+
+func TestCodecsRoundTrip(t *testing.T) {
+	for _, codec := range []Codec{JSONCodec, MsgpackCodec} {
+		data, err := codec.Marshal(position{X: 1, Y: 2})
+		testutil.Ok(t, err)
+		var p position
+		testutil.Ok(t, codec.Unmarshal(data, &p))
+		testutil.Equals(t, position{X: 1, Y: 2}, p)
+	}
+
+	data, err := ProtobufCodec.Marshal(&cellaserv.Publish{Event: "foo"})
+	testutil.Ok(t, err)
+	var pub cellaserv.Publish
+	testutil.Ok(t, ProtobufCodec.Unmarshal(data, &pub))
+	testutil.Equals(t, "foo", pub.Event)
+
+	_, err = ProtobufCodec.Marshal(position{})
+	testutil.NotOk(t, err, "marshal of a non protobuf message")
+}
+
+func TestServiceStubCodec(t *testing.T) {
+	withTestBroker(t, ":4201", func(clientOpts ClientOpts) {
+		connService := NewClient(clientOpts)
+		trajman := connService.NewService("trajman", "")
+		trajman.HandleRequestFunc("mirror", func(ctx context.Context, req *cellaserv.Request) (interface{}, error) {
+			var p position
+			if err := UnmarshalRequest(req, &p); err != nil {
+				return nil, err
+			}
+			return position{X: p.Y, Y: p.X}, nil
+		})
+		connService.RegisterService(trajman)
+		time.Sleep(50 * time.Millisecond)
+
+		connRequest := NewClient(clientOpts)
+		for _, codec := range []Codec{JSONCodec, MsgpackCodec} {
+			stub := NewServiceStub(connRequest, "trajman", "", WithCodec(codec))
+			var p position
+			testutil.Ok(t, stub.Call("mirror", position{X: 1, Y: 2}, &p))
+			testutil.Equals(t, position{X: 2, Y: 1}, p)
+		}
+	})
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/evolutek/cellaserv3/common"
+	"github.com/gorilla/websocket"
+)
+
+// splitAddress returns the network and the address of a cellaserv address.
+// Supported syntaxes:
+// - host:port or tcp://host:port for TCP
+// - unix:///path/to/socket for unix domain sockets
+// - ws://host:port/path or wss://host:port/path for WebSocket, the address
+// being the whole URL
+func splitAddress(csAddr string) (network string, address string, err error) {
+	i := strings.Index(csAddr, "://")
+	if i < 0 {
+		return "tcp", csAddr, nil
+	}
+	scheme, address := csAddr[:i], csAddr[i+len("://"):]
+	switch scheme {
+	case "tcp", "unix":
+		return scheme, address, nil
+	case "ws", "wss":
+		return scheme, csAddr, nil
+	default:
+		return "", "", fmt.Errorf("Unsupported cellaserv address scheme: %q", scheme)
+	}
+}
+
+// dial connects to the cellaserv address, using TLS if tlsConfig is not nil.
+func dial(csAddr string, tlsConfig *tls.Config) (net.Conn, error) {
+	network, address, err := splitAddress(csAddr)
+	if err != nil {
+		return nil, err
+	}
+	if network == "ws" || network == "wss" {
+		return dialWebSocket(address, tlsConfig)
+	}
+	if tlsConfig != nil {
+		return tls.Dial(network, address, tlsConfig)
+	}
+	return net.Dial(network, address)
+}
+
+// dialWebSocket connects to the cellaserv WebSocket endpoint at url.
+func dialWebSocket(url string, tlsConfig *tls.Config) (net.Conn, error) {
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = tlsConfig
+	ws, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return common.NewWebSocketConn(ws), nil
+}
@@ -0,0 +1,137 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	cellaserv "github.com/evolutek/cellaserv3-protobuf"
+	"github.com/evolutek/cellaserv3/broker"
+	"github.com/evolutek/cellaserv3/common"
+	"github.com/evolutek/cellaserv3/testutil"
+	"github.com/gorilla/websocket"
+)
+
+func TestSplitAddress(t *testing.T) {
+	for _, tc := range []struct {
+		csAddr  string
+		network string
+		address string
+	}{
+		{"localhost:4200", "tcp", "localhost:4200"},
+		{"tcp://localhost:4200", "tcp", "localhost:4200"},
+		{"unix:///run/cellaserv.sock", "unix", "/run/cellaserv.sock"},
+		{"ws://localhost:4280/api/v1/connect", "ws", "ws://localhost:4280/api/v1/connect"},
+	} {
+		network, address, err := splitAddress(tc.csAddr)
+		testutil.Ok(t, err)
+		testutil.Equals(t, tc.network, network)
+		testutil.Equals(t, tc.address, address)
+	}
+
+	_, _, err := splitAddress("udp://localhost:4200")
+	testutil.NotOk(t, err, "unsupported scheme")
+}
+
+func TestDialUnix(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "cellaserv")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	socketPath := filepath.Join(tmpDir, "cellaserv.sock")
+	l, err := net.Listen("unix", socketPath)
+	testutil.Ok(t, err)
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+
+	conn, err := dial("unix://"+socketPath, nil)
+	testutil.Ok(t, err)
+	conn.Close()
+}
+
+func TestDialTLS(t *testing.T) {
+	serverConfig, clientConfig := testutil.NewTLSConfigs(t)
+	serverConfig.ClientAuth = tls.RequireAndVerifyClientCert
+
+	l, err := tls.Listen("tcp", "localhost:0", serverConfig)
+	testutil.Ok(t, err)
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.(*tls.Conn).Handshake()
+	}()
+
+	conn, err := dial(l.Addr().String(), clientConfig)
+	testutil.Ok(t, err)
+	defer conn.Close()
+	testutil.Ok(t, conn.(*tls.Conn).Handshake())
+}
+
+func TestDialWebSocket(t *testing.T) {
+	b := broker.New(broker.Options{}, common.NewLogger("broker"))
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Could not upgrade: %s", err)
+			return
+		}
+		conn := common.NewWebSocketConn(ws)
+		defer conn.Close()
+		b.ServeConn(conn)
+	}))
+	defer srv.Close()
+
+	opts := ClientOpts{CellaservAddr: "ws" + strings.TrimPrefix(srv.URL, "http")}
+	c := NewClient(opts)
+	defer c.Close()
+
+	s := c.NewService("echo", "")
+	s.HandleRequestFunc("echo", func(ctx context.Context, req *cellaserv.Request) (interface{}, error) {
+		return RawData(req.Data), nil
+	})
+	c.RegisterService(s)
+	time.Sleep(50 * time.Millisecond)
+
+	resp, err := NewServiceStub(c, "echo", "").RequestRaw("echo", []byte(`"hello"`))
+	testutil.Ok(t, err)
+	testutil.Equals(t, `"hello"`, string(resp))
+}
+
+func TestDialLocal(t *testing.T) {
+	b := broker.New(broker.Options{}, common.NewLogger("broker"))
+
+	srvc := NewClient(ClientOpts{Dial: b.DialLocal})
+	defer srvc.Close()
+	s := srvc.NewService("echo", "")
+	s.HandleRequestFunc("echo", func(ctx context.Context, req *cellaserv.Request) (interface{}, error) {
+		return RawData(req.Data), nil
+	})
+	srvc.RegisterService(s)
+	time.Sleep(50 * time.Millisecond)
+
+	c := NewClient(ClientOpts{Dial: b.DialLocal})
+	defer c.Close()
+	resp, err := NewServiceStub(c, "echo", "").RequestRaw("echo", []byte("local"))
+	testutil.Ok(t, err)
+	testutil.Equals(t, "local", string(resp))
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+
+	cellaserv "github.com/evolutek/cellaserv3-protobuf"
+)
+
+// ErrConnectionClosed is returned for requests whose reply cannot be
+// received because the connection to cellaserv is closed.
+var ErrConnectionClosed = errors.New("Connection to cellaserv closed")
+
+// ErrTimeout is returned for requests whose reply is not received in time,
+// either because ClientOpts.RequestTimeout elapsed or because cellaserv timed
+// out the request. Test for it with errors.Is.
+var ErrTimeout = errors.New("Request timed out")
+
+// TransportError is returned when a request could not be sent to cellaserv.
+type TransportError struct {
+	Err error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("Could not send request: %s", e.Err)
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// ReplyError is returned when the reply to a request has its error field
+// set, either by the service or by cellaserv.
+type ReplyError struct {
+	Type cellaserv.Reply_Error_Type
+	What string
+}
+
+func (e *ReplyError) Error() string {
+	if e.What != "" {
+		return fmt.Sprintf("%s: %s", e.Type, e.What)
+	}
+	return e.Type.String()
+}
+
+// Is makes the timeout errors reported by cellaserv match ErrTimeout.
+func (e *ReplyError) Is(target error) bool {
+	return target == ErrTimeout && e.Type == cellaserv.Reply_Error_Timeout
+}
+
+// PanicError is returned to the requester when the request handler panicked.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("Panic: %v\n%s", e.Value, e.Stack)
+}
@@ -1,10 +1,10 @@
 package main
 
+//go:generate go run github.com/evolutek/cellaserv3/cmd/cellaserv-stubgen -i date.json -o date_stub.go
+
 import (
-	"encoding/json"
 	"fmt"
 	"log"
-	"time"
 
 	"github.com/evolutek/cellaserv3/client"
 )
@@ -13,19 +13,12 @@ func main() {
 	// Connect to cellaserv
 	conn := client.NewClient(client.ClientOpts{})
 	// Create date service stub
-	date := client.NewServiceStub(conn, "date", "")
+	date := NewDateStub(conn, "")
 	// Request date.time()
-	respBytes, err := date.Request("time", nil)
+	resp, err := date.Time()
 	if err != nil {
 		log.Printf("date.time() query failed: %s", err)
 		return
 	}
-	var resp time.Time
-	// Process response
-	err = json.Unmarshal(respBytes, &resp)
-	if err != nil {
-		log.Printf("Could not unmarshal response: %s", err)
-		return
-	}
 	fmt.Println(resp)
 }
@@ -0,0 +1,40 @@
+// Code generated by cellaserv-stubgen. DO NOT EDIT.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/evolutek/cellaserv3/client"
+)
+
+// DateStub is a typed stub of the date service.
+type DateStub struct {
+	stub *client.ServiceStub
+}
+
+// NewDateStub returns a stub of the date service with the given
+// identification.
+func NewDateStub(c *client.Client, identification string) *DateStub {
+	return &DateStub{stub: client.NewServiceStub(c, "date", identification)}
+}
+
+// Stub returns the untyped service stub.
+func (s *DateStub) Stub() *client.ServiceStub {
+	return s.stub
+}
+
+// Time returns the current time of the date service.
+func (s *DateStub) Time() (time.Time, error) {
+	var resp time.Time
+	respBytes, err := s.stub.RequestNoData("time")
+	if err != nil {
+		return resp, err
+	}
+	if err := json.Unmarshal(respBytes, &resp); err != nil {
+		return resp, fmt.Errorf("Could not unmarshal reply of date.time(): %w", err)
+	}
+	return resp, nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"time"
 
 	cellaserv "github.com/evolutek/cellaserv3-protobuf"
@@ -14,7 +15,7 @@ func runDateService(opts client.ClientOpts) {
 	// Prepare service for registration
 	date := conn.NewService("date", "")
 	// Handle "time" request
-	date.HandleRequestFunc("time", func(_ *cellaserv.Request) (interface{}, error) {
+	date.HandleRequestFunc("time", func(context.Context, *cellaserv.Request) (interface{}, error) {
 		return time.Now(), nil
 	})
 	// Handle "killall" event
@@ -10,7 +10,7 @@ import (
 func main() {
 	// Connect to cellaserv
 	conn := client.NewClient(client.ClientOpts{})
-	err := conn.Subscribe("date", func(eventName string, eventBytes []byte) {
+	_, err := conn.Subscribe("date", func(eventName string, eventBytes []byte) {
 		// Decode
 		var eventData string
 		err := json.Unmarshal(eventBytes, &eventData)
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 
 	cellaserv "github.com/evolutek/cellaserv3-protobuf"
@@ -14,7 +15,7 @@ func main() {
 	// Prepare service for registration
 	echo := conn.NewService("echo", "")
 	// Handle "time" request
-	echo.HandleRequestFunc("echo", func(req *cellaserv.Request) (interface{}, error) {
+	echo.HandleRequestFunc("echo", func(ctx context.Context, req *cellaserv.Request) (interface{}, error) {
 		// Parse json from request
 		var reqObj interface{}
 		err := json.Unmarshal(req.GetData(), &reqObj)
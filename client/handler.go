@@ -0,0 +1,79 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	cellaserv "github.com/evolutek/cellaserv3-protobuf"
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// Handle registers a typed request handler. The handler is a function of one
+// of the forms:
+//
+//	func(ctx context.Context, args In) (Out, error)
+//	func(ctx context.Context, args In) error
+//	func(ctx context.Context) (Out, error)
+//	func(ctx context.Context) error
+//
+// The request data is unmarshaled into args with the codec of the request,
+// args is left to its zero value if the request has no data. The result is
+// marshaled in the reply. Handle panics if handler does not have one of these
+// forms.
+func (s *service) Handle(method string, handler interface{}) {
+	f, err := typedHandler(handler)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid handler for %s.%s: %s", s, method, err))
+	}
+	s.HandleRequestFunc(method, f)
+}
+
+// typedHandler wraps a typed handler in a RequestHandlerFunc.
+func typedHandler(handler interface{}) (RequestHandlerFunc, error) {
+	fn := reflect.ValueOf(handler)
+	typ := fn.Type()
+	if typ.Kind() != reflect.Func {
+		return nil, fmt.Errorf("Not a function: %s", typ)
+	}
+	if typ.NumIn() < 1 || typ.NumIn() > 2 || typ.In(0) != contextType {
+		return nil, fmt.Errorf("Arguments must be (context.Context) or (context.Context, In): %s", typ)
+	}
+	if typ.NumOut() < 1 || typ.NumOut() > 2 || typ.Out(typ.NumOut()-1) != errorType {
+		return nil, fmt.Errorf("Results must be (error) or (Out, error): %s", typ)
+	}
+
+	var argsType reflect.Type
+	if typ.NumIn() == 2 {
+		argsType = typ.In(1)
+	}
+	hasResult := typ.NumOut() == 2
+
+	return func(ctx context.Context, req *cellaserv.Request) (interface{}, error) {
+		in := []reflect.Value{reflect.ValueOf(ctx)}
+		if argsType != nil {
+			args := reflect.New(argsType)
+			if len(req.Data) > 0 {
+				if err := UnmarshalRequest(req, args.Interface()); err != nil {
+					return nil, fmt.Errorf("Could not unmarshal arguments: %s", err)
+				}
+			}
+			in = append(in, args.Elem())
+		}
+
+		out := fn.Call(in)
+
+		errValue := out[len(out)-1]
+		if !errValue.IsNil() {
+			return nil, errValue.Interface().(error)
+		}
+		if !hasResult {
+			return nil, nil
+		}
+		return out[0].Interface(), nil
+	}, nil
+}
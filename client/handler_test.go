@@ -0,0 +1,68 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+
+	cellaserv "github.com/evolutek/cellaserv3-protobuf"
+	"github.com/evolutek/cellaserv3/testutil"
+)
+
+type moveArgs struct {
+	X, Y float64
+}
+
+type moveResult struct {
+	Distance float64
+}
+
+func TestHandle(t *testing.T) {
+	_, conn := net.Pipe()
+	c := newClient(conn, ClientOpts{})
+	defer c.Close()
+
+	s := c.NewService("trajman", "")
+	s.Handle("move", func(ctx context.Context, args moveArgs) (moveResult, error) {
+		return moveResult{Distance: args.X + args.Y}, nil
+	})
+	s.Handle("stop", func(ctx context.Context) error {
+		return fmt.Errorf("Already stopped")
+	})
+	s.Handle("goto", func(ctx context.Context, args *moveArgs) error {
+		if args == nil {
+			return fmt.Errorf("nil args")
+		}
+		return nil
+	})
+
+	data, err := s.handleRequest(context.Background(), &cellaserv.Request{Data: []byte(`{"X": 1, "Y": 2}`)}, "move", JSONCodec)
+	testutil.Ok(t, err)
+	var res moveResult
+	testutil.Ok(t, json.Unmarshal(data, &res))
+	testutil.Equals(t, moveResult{Distance: 3}, res)
+
+	_, err = s.handleRequest(context.Background(), &cellaserv.Request{}, "stop", JSONCodec)
+	testutil.Equals(t, "Already stopped", err.Error())
+
+	_, err = s.handleRequest(context.Background(), &cellaserv.Request{Data: []byte(`{"X": 1}`)}, "goto", JSONCodec)
+	testutil.Ok(t, err)
+
+	_, err = s.handleRequest(context.Background(), &cellaserv.Request{Data: []byte(`not json`)}, "move", JSONCodec)
+	testutil.NotOk(t, err, "invalid arguments were accepted")
+}
+
+func TestHandleInvalid(t *testing.T) {
+	for _, handler := range []interface{}{
+		42,
+		func() error { return nil },
+		func(ctx context.Context, a, b int) error { return nil },
+		func(ctx context.Context) int { return 0 },
+		func(ctx context.Context) (int, int) { return 0, 0 },
+	} {
+		_, err := typedHandler(handler)
+		testutil.NotOk(t, err, "invalid handler %T was accepted", handler)
+	}
+}
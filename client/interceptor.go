@@ -0,0 +1,60 @@
+package client
+
+import (
+	cellaserv "github.com/evolutek/cellaserv3-protobuf"
+)
+
+// RequestInvoker sends a request and returns its reply.
+type RequestInvoker func(req *cellaserv.Request) (*cellaserv.Reply, error)
+
+// RequestInterceptor wraps the invoker of outgoing requests. It can inspect or
+// modify the request and the reply, or not call the next invoker at all.
+type RequestInterceptor func(next RequestInvoker) RequestInvoker
+
+// PublishInvoker sends a publish.
+type PublishInvoker func(pub *cellaserv.Publish) error
+
+// PublishInterceptor wraps the invoker of outgoing publishes.
+type PublishInterceptor func(next PublishInvoker) PublishInvoker
+
+// Use adds an interceptor to the chain applied to outgoing requests. The
+// first interceptor added is the outermost one.
+func (c *Client) Use(interceptor RequestInterceptor) {
+	c.mtx.Lock()
+	c.requestInterceptors = append(c.requestInterceptors, interceptor)
+	c.mtx.Unlock()
+}
+
+// UsePublish adds an interceptor to the chain applied to outgoing publishes.
+// The first interceptor added is the outermost one.
+func (c *Client) UsePublish(interceptor PublishInterceptor) {
+	c.mtx.Lock()
+	c.publishInterceptors = append(c.publishInterceptors, interceptor)
+	c.mtx.Unlock()
+}
+
+// invokeRequest sends the request through the interceptor chain.
+func (c *Client) invokeRequest(req *cellaserv.Request) (*cellaserv.Reply, error) {
+	c.mtx.RLock()
+	interceptors := c.requestInterceptors
+	c.mtx.RUnlock()
+
+	invoker := RequestInvoker(c.sendRequestWaitForReply)
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		invoker = interceptors[i](invoker)
+	}
+	return invoker(req)
+}
+
+// invokePublish sends the publish through the interceptor chain.
+func (c *Client) invokePublish(pub *cellaserv.Publish) error {
+	c.mtx.RLock()
+	interceptors := c.publishInterceptors
+	c.mtx.RUnlock()
+
+	invoker := PublishInvoker(c.sendPublish)
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		invoker = interceptors[i](invoker)
+	}
+	return invoker(pub)
+}
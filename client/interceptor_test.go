@@ -0,0 +1,53 @@
+package client
+
+import (
+	"net"
+	"testing"
+
+	cellaserv "github.com/evolutek/cellaserv3-protobuf"
+	"github.com/evolutek/cellaserv3/testutil"
+)
+
+func TestRequestInterceptors(t *testing.T) {
+	_, conn := net.Pipe()
+	c := newClient(conn, ClientOpts{})
+	defer c.Close()
+
+	var calls []string
+	c.Use(func(next RequestInvoker) RequestInvoker {
+		return func(req *cellaserv.Request) (*cellaserv.Reply, error) {
+			calls = append(calls, "outer:"+req.Method)
+			return next(req)
+		}
+	})
+	c.Use(func(next RequestInvoker) RequestInvoker {
+		return func(req *cellaserv.Request) (*cellaserv.Reply, error) {
+			calls = append(calls, "inner:"+req.Method)
+			// Short-circuit the request
+			return &cellaserv.Reply{Id: req.Id, Data: []byte("42")}, nil
+		}
+	})
+
+	data, err := NewServiceStub(c, "date", "").Request("time", nil)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []byte("42"), data)
+	testutil.Equals(t, []string{"outer:time", "inner:time"}, calls)
+}
+
+func TestPublishInterceptors(t *testing.T) {
+	_, conn := net.Pipe()
+	c := newClient(conn, ClientOpts{})
+	defer c.Close()
+
+	var published []string
+	c.UsePublish(func(next PublishInvoker) PublishInvoker {
+		return func(pub *cellaserv.Publish) error {
+			// Drop the publish
+			published = append(published, pub.Event)
+			return nil
+		}
+	})
+
+	c.PublishRaw("foo", nil)
+	testutil.Equals(t, []string{"foo"}, published)
+}
@@ -0,0 +1,39 @@
+package client
+
+import "context"
+
+// Interface is the API of Client used to talk to other services. Depend on it
+// instead of *Client to replace the client with a mock in tests, see the
+// clientmock package.
+type Interface interface {
+	Publish(event string, data interface{}) error
+	PublishContext(ctx context.Context, event string, data interface{}) error
+	PublishRaw(event string, data []byte)
+	Subscribe(eventPattern string, handler func(eventName string, eventData []byte)) (SubscriptionId, error)
+	SubscribeUntil(eventPattern string, handler func(eventName string, eventData []byte) bool) (SubscriptionId, error)
+	Unsubscribe(id SubscriptionId) error
+	// Stub returns a stub of the service.
+	Stub(name string, identification string, opts ...StubOption) Stub
+	Quit() <-chan struct{}
+	Close()
+}
+
+// Stub is the API of ServiceStub.
+type Stub interface {
+	RequestContext(ctx context.Context, method string, data interface{}) ([]byte, error)
+	CallContext(ctx context.Context, method string, args interface{}, reply interface{}) error
+	Request(method string, data interface{}) ([]byte, error)
+	RequestNoData(method string) ([]byte, error)
+	RequestRaw(method string, data []byte) ([]byte, error)
+	Call(method string, args interface{}, reply interface{}) error
+}
+
+var (
+	_ Interface = (*Client)(nil)
+	_ Stub      = (*ServiceStub)(nil)
+)
+
+// Stub returns a stub of the service, see NewServiceStub.
+func (c *Client) Stub(name string, identification string, opts ...StubOption) Stub {
+	return NewServiceStub(c, name, identification, opts...)
+}
@@ -0,0 +1,87 @@
+package client
+
+import (
+	"net"
+	"sync/atomic"
+
+	cellaserv "github.com/evolutek/cellaserv3-protobuf"
+	"github.com/evolutek/cellaserv3/common"
+	"github.com/golang/protobuf/proto"
+)
+
+// requestConn returns the connection used to send the next request. Requests
+// are spread in round-robin over the main connection and the additional
+// request connections.
+func (c *Client) requestConn() net.Conn {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	if len(c.requestConns) == 0 {
+		return c.conn
+	}
+	i := atomic.AddUint32(&c.requestConnIdx, 1) % uint32(len(c.requestConns)+1)
+	if i == 0 {
+		return c.conn
+	}
+	return c.requestConns[i-1]
+}
+
+// addRequestConn adds a connection used to send requests. cellaserv sends the
+// replies on the connection that sent the request.
+func (c *Client) addRequestConn(conn net.Conn) {
+	c.mtx.Lock()
+	c.requestConns = append(c.requestConns, conn)
+	c.mtx.Unlock()
+
+	go c.serveRequestConn(conn)
+	go func() {
+		<-c.quitCh
+		conn.Close()
+	}()
+}
+
+// removeRequestConn stops using a closed request connection.
+func (c *Client) removeRequestConn(conn net.Conn) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for i, rc := range c.requestConns {
+		if rc == conn {
+			c.requestConns = append(c.requestConns[:i], c.requestConns[i+1:]...)
+			return
+		}
+	}
+}
+
+// serveRequestConn dispatches the replies received on a request connection.
+// The connection is dropped on the first receive error.
+func (c *Client) serveRequestConn(conn net.Conn) {
+	defer c.removeRequestConn(conn)
+
+	for {
+		closed, _, msg, err := common.RecvMessage(conn)
+		if closed {
+			return
+		}
+		if err != nil {
+			select {
+			case <-c.quitCh:
+			default:
+				c.logger.Errorf("Closing request connection: %s", err)
+			}
+			return
+		}
+		if msg.GetType() != cellaserv.Message_Reply {
+			c.logger.Warnf("Unexpected message type on request connection: %d", msg.GetType())
+			continue
+		}
+		rep := &cellaserv.Reply{}
+		if err := proto.Unmarshal(msg.GetContent(), rep); err != nil {
+			c.logger.Errorf("Could not unmarshal reply: %s", err)
+			continue
+		}
+		if err := c.dispatchReply(rep); err != nil {
+			c.logger.Errorf("Could not handle reply: %s", err)
+		}
+	}
+}
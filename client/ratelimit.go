@@ -0,0 +1,132 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	cellaserv "github.com/evolutek/cellaserv3-protobuf"
+	"github.com/evolutek/cellaserv3/common"
+)
+
+// LimitPolicy selects what happens to the events published over the rate
+// limit.
+type LimitPolicy int
+
+const (
+	// DropExcess drops the events published over the limit.
+	DropExcess LimitPolicy = iota
+	// CoalesceExcess keeps the last event published over the limit, and
+	// publishes it as soon as the limit allows it.
+	CoalesceExcess
+)
+
+// PublishRateLimit limits the rate of the events published with the same
+// name, using a token bucket.
+type PublishRateLimit struct {
+	// Number of events per second, zero disables the limit
+	Rate float64
+	// Number of events that can be published at once. Defaults to 1.
+	Burst  int
+	Policy LimitPolicy
+}
+
+// tokenBucket tracks the rate of an event name.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+
+	// Coalesced event waiting for a token, and the invoker to send it with
+	pending     *cellaserv.Publish
+	pendingNext PublishInvoker
+	timer       *time.Timer
+}
+
+type publishLimiter struct {
+	limit  PublishRateLimit
+	logger common.Logger
+
+	mtx     sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newPublishLimiter(limit PublishRateLimit, logger common.Logger) *publishLimiter {
+	if limit.Burst <= 0 {
+		limit.Burst = 1
+	}
+	return &publishLimiter{
+		limit:   limit,
+		logger:  logger,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// interceptor is the publish interceptor enforcing the limit.
+func (l *publishLimiter) interceptor(next PublishInvoker) PublishInvoker {
+	return func(pub *cellaserv.Publish) error {
+		return l.publish(pub, next)
+	}
+}
+
+// bucket returns the refilled bucket of the event. Must be called with the
+// lock held.
+func (l *publishLimiter) bucket(event string) *tokenBucket {
+	now := time.Now()
+	b, ok := l.buckets[event]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.limit.Burst), last: now}
+		l.buckets[event] = b
+		return b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.limit.Rate
+	if b.tokens > float64(l.limit.Burst) {
+		b.tokens = float64(l.limit.Burst)
+	}
+	b.last = now
+	return b
+}
+
+func (l *publishLimiter) publish(pub *cellaserv.Publish, next PublishInvoker) error {
+	event := pub.GetEvent()
+
+	l.mtx.Lock()
+	b := l.bucket(event)
+	if b.tokens >= 1 {
+		b.tokens--
+		// The coalesced event is older than this one
+		b.pending = nil
+		l.mtx.Unlock()
+		return next(pub)
+	}
+
+	if l.limit.Policy == CoalesceExcess {
+		b.pending = pub
+		b.pendingNext = next
+		if b.timer == nil {
+			wait := time.Duration((1 - b.tokens) / l.limit.Rate * float64(time.Second))
+			b.timer = time.AfterFunc(wait, func() { l.flush(event) })
+		}
+	} else {
+		l.logger.Debugf("Dropping event %q over the rate limit", event)
+	}
+	l.mtx.Unlock()
+	return nil
+}
+
+// flush publishes the coalesced event.
+func (l *publishLimiter) flush(event string) {
+	l.mtx.Lock()
+	b := l.bucket(event)
+	b.timer = nil
+	pub, next := b.pending, b.pendingNext
+	b.pending, b.pendingNext = nil, nil
+	if pub == nil {
+		l.mtx.Unlock()
+		return
+	}
+	b.tokens--
+	l.mtx.Unlock()
+
+	if err := next(pub); err != nil {
+		l.logger.Errorf("Could not publish coalesced event %q: %s", event, err)
+	}
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	cellaserv "github.com/evolutek/cellaserv3-protobuf"
+	"github.com/evolutek/cellaserv3/common"
+	"github.com/evolutek/cellaserv3/testutil"
+)
+
+type publishRecorder struct {
+	mtx  sync.Mutex
+	pubs []*cellaserv.Publish
+}
+
+func (r *publishRecorder) invoke(pub *cellaserv.Publish) error {
+	r.mtx.Lock()
+	r.pubs = append(r.pubs, pub)
+	r.mtx.Unlock()
+	return nil
+}
+
+func (r *publishRecorder) data() []string {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	var data []string
+	for _, pub := range r.pubs {
+		data = append(data, pub.GetEvent()+":"+string(pub.GetData()))
+	}
+	return data
+}
+
+func TestPublishRateLimitDrop(t *testing.T) {
+	var rec publishRecorder
+	l := newPublishLimiter(PublishRateLimit{Rate: 10, Burst: 2}, common.NewLogger("test"))
+	invoke := l.interceptor(rec.invoke)
+
+	for i := 0; i < 5; i++ {
+		testutil.Ok(t, invoke(&cellaserv.Publish{Event: "foo", Data: []byte{'0' + byte(i)}}))
+	}
+	// Events are limited by name
+	testutil.Ok(t, invoke(&cellaserv.Publish{Event: "bar", Data: []byte("0")}))
+	testutil.Equals(t, []string{"foo:0", "foo:1", "bar:0"}, rec.data())
+
+	// A token is available after 100ms
+	time.Sleep(120 * time.Millisecond)
+	testutil.Ok(t, invoke(&cellaserv.Publish{Event: "foo", Data: []byte("5")}))
+	testutil.Ok(t, invoke(&cellaserv.Publish{Event: "foo", Data: []byte("6")}))
+	testutil.Equals(t, []string{"foo:0", "foo:1", "bar:0", "foo:5"}, rec.data())
+}
+
+func TestPublishRateLimitCoalesce(t *testing.T) {
+	var rec publishRecorder
+	l := newPublishLimiter(PublishRateLimit{Rate: 20, Policy: CoalesceExcess}, common.NewLogger("test"))
+	invoke := l.interceptor(rec.invoke)
+
+	for i := 0; i < 5; i++ {
+		testutil.Ok(t, invoke(&cellaserv.Publish{Event: "foo", Data: []byte{'0' + byte(i)}}))
+	}
+	testutil.Equals(t, []string{"foo:0"}, rec.data())
+
+	// The last event is published once a token is available
+	time.Sleep(100 * time.Millisecond)
+	testutil.Equals(t, []string{"foo:0", "foo:4"}, rec.data())
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"net"
+	"time"
+
+	"github.com/evolutek/cellaserv3/broker/cellaserv/api"
+)
+
+// currentConn returns the connection to cellaserv.
+func (c *Client) currentConn() net.Conn {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	return c.conn
+}
+
+// connLost returns the channel closed when the current connection is lost.
+func (c *Client) connLost() <-chan struct{} {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	return c.connLostCh
+}
+
+// reconnect replaces the lost connection to cellaserv and restores the client
+// state. It returns false if the client quit before being reconnected.
+func (c *Client) reconnect(cause error) bool {
+	c.logger.Warnf("Connection to cellaserv lost, reconnecting")
+
+	// Fail the requests in flight
+	c.mtx.Lock()
+	close(c.connLostCh)
+	c.clientId = ""
+	c.mtx.Unlock()
+
+	if c.onDisconnect != nil {
+		c.onDisconnect(c, cause)
+	}
+
+	var conn net.Conn
+	for conn == nil {
+		select {
+		case <-c.quitCh:
+			return false
+		case <-time.After(c.reconnectInterval):
+		}
+		var err error
+		conn, err = c.redial()
+		if err != nil {
+			c.logger.Warnf("Could not reconnect to cellaserv: %s", err)
+		}
+	}
+
+	c.mtx.Lock()
+	c.conn = conn
+	c.connLostCh = make(chan struct{})
+	c.mtx.Unlock()
+
+	// Close() was called while reconnecting
+	select {
+	case <-c.quitCh:
+		conn.Close()
+		return false
+	default:
+	}
+
+	c.logger.Infof("Reconnected to cellaserv")
+	c.restore()
+	return true
+}
+
+// restore sends the state of the client to cellaserv after reconnecting.
+func (c *Client) restore() {
+	for _, idents := range c.services {
+		for _, s := range idents {
+			c.sendRegister(s)
+		}
+	}
+
+	c.mtx.RLock()
+	patterns := make(map[string]bool)
+	for _, s := range c.subscribers {
+		patterns[s.eventPattern] = true
+	}
+	c.mtx.RUnlock()
+	for pattern := range patterns {
+		c.sendSubscribe(pattern)
+	}
+
+	// Requests are answered once this function returns and messages are
+	// received again
+	go func() {
+		if c.name != "" {
+			c.Cs.Request("name_client", api.NameClientRequest{Name: c.name})
+		}
+		for name, idents := range c.spies {
+			for ident := range idents {
+				c.sendSpy(name, ident)
+			}
+		}
+		if c.onReconnect != nil {
+			c.onReconnect(c)
+		}
+	}()
+}
@@ -0,0 +1,87 @@
+package client
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	cellaserv "github.com/evolutek/cellaserv3-protobuf"
+	"github.com/evolutek/cellaserv3/common"
+	"github.com/evolutek/cellaserv3/testutil"
+)
+
+func TestReconnect(t *testing.T) {
+	server1, client1 := net.Pipe()
+	server2, client2 := net.Pipe()
+	defer server2.Close()
+
+	// The first server receives the request, and never answers it
+	requestReceived := make(chan struct{})
+	go func() {
+		for {
+			closed, _, msg, err := common.RecvMessage(server1)
+			if closed || err != nil {
+				return
+			}
+			if msg.GetType() == cellaserv.Message_Request {
+				close(requestReceived)
+			}
+		}
+	}()
+
+	connected := make(chan struct{})
+	disconnected := make(chan struct{})
+	reconnected := make(chan struct{})
+	c := newClient(client1, ClientOpts{
+		ReconnectInterval: 10 * time.Millisecond,
+		OnConnect:         func(*Client) { close(connected) },
+		OnDisconnect:      func(*Client, error) { close(disconnected) },
+		OnReconnect:       func(*Client) { close(reconnected) },
+		redial:            func() (net.Conn, error) { return client2, nil },
+	})
+	defer c.Close()
+	<-connected
+
+	s := c.NewService("date", "")
+	s.HandleRequestFunc("time", func(context.Context, *cellaserv.Request) (interface{}, error) {
+		return nil, nil
+	})
+	c.RegisterService(s)
+	_, err := c.Subscribe("tick", func(string, []byte) {})
+	testutil.Ok(t, err)
+
+	requestErr := make(chan error)
+	go func() {
+		_, err := NewServiceStub(c, "trajman", "").RequestNoData("get_position")
+		requestErr <- err
+	}()
+	<-requestReceived
+
+	server1.Close()
+	<-disconnected
+
+	// The request in flight fails
+	select {
+	case err := <-requestErr:
+		testutil.Equals(t, ErrConnectionClosed, err)
+	case <-time.After(time.Second):
+		t.Fatal("Request in flight did not fail")
+	}
+
+	// The service and the subscription are restored on the new connection
+	restored := make(map[cellaserv.Message_MessageType]bool)
+	for len(restored) < 2 {
+		closed, _, msg, err := common.RecvMessage(server2)
+		testutil.Assert(t, !closed && err == nil, "Could not receive message: %v", err)
+		restored[msg.GetType()] = true
+	}
+	testutil.Assert(t, restored[cellaserv.Message_Register], "Service not registered again")
+	testutil.Assert(t, restored[cellaserv.Message_Subscribe], "Subscription not restored")
+
+	select {
+	case <-reconnected:
+	case <-time.After(time.Second):
+		t.Fatal("OnReconnect not called")
+	}
+}
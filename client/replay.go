@@ -0,0 +1,38 @@
+package client
+
+import "sync"
+
+// eventRing keeps the last events matched by an event pattern, so that they
+// can be replayed to new subscribers of the pattern.
+type eventRing struct {
+	mtx    sync.Mutex
+	events []Event
+	// Index of the next event to overwrite once the ring is full
+	next int
+}
+
+func newEventRing(size int) *eventRing {
+	return &eventRing{events: make([]Event, 0, size)}
+}
+
+func (r *eventRing) add(e Event) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if len(r.events) < cap(r.events) {
+		r.events = append(r.events, e)
+		return
+	}
+	r.events[r.next] = e
+	r.next = (r.next + 1) % len(r.events)
+}
+
+// list returns the events of the ring, oldest first.
+func (r *eventRing) list() []Event {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	events := make([]Event, 0, len(r.events))
+	events = append(events, r.events[r.next:]...)
+	return append(events, r.events[:r.next]...)
+}
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	cellaserv "github.com/evolutek/cellaserv3-protobuf"
+	"github.com/evolutek/cellaserv3/common"
+	"github.com/evolutek/cellaserv3/testutil"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestEventRing(t *testing.T) {
+	r := newEventRing(3)
+	testutil.Equals(t, []Event{}, r.list())
+
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		r.add(Event{Name: name})
+	}
+	testutil.Equals(t, []Event{{Name: "c"}, {Name: "d"}, {Name: "e"}}, r.list())
+}
+
+func TestEventReplay(t *testing.T) {
+	server, client := net.Pipe()
+
+	// Discard messages sent by the client
+	go func() {
+		for {
+			closed, _, _, err := common.RecvMessage(server)
+			if closed || err != nil {
+				return
+			}
+		}
+	}()
+
+	c := newClient(client, ClientOpts{EventReplaySize: 2})
+	defer c.Close()
+
+	_, err := c.Subscribe("state.*", func(string, []byte) {})
+	testutil.Ok(t, err)
+
+	for _, event := range []string{"state.init", "state.ready", "state.running"} {
+		pubBytes, _ := proto.Marshal(&cellaserv.Publish{Event: event})
+		msg := &cellaserv.Message{Type: cellaserv.Message_Publish, Content: pubBytes}
+		testutil.Ok(t, common.SendMessage(server, msg))
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// A late subscriber receives the last events
+	events, cancel, err := c.SubscribeChan("state.*")
+	testutil.Ok(t, err)
+	defer cancel()
+	for _, name := range []string{"state.ready", "state.running"} {
+		select {
+		case e := <-events:
+			testutil.Equals(t, name, e.Name)
+		case <-time.After(time.Second):
+			t.Fatal("Event not replayed")
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"errors"
+	"time"
+
+	cellaserv "github.com/evolutek/cellaserv3-protobuf"
+)
+
+const (
+	defaultRetryBackoff    = 100 * time.Millisecond
+	defaultRetryMaxBackoff = 2 * time.Second
+)
+
+// RetryPolicy configures how a ServiceStub retries failed requests. Only use
+// it for idempotent methods, as a request that timed out may still have been
+// handled by the service.
+type RetryPolicy struct {
+	// Maximum number of attempts, including the first one
+	MaxAttempts int
+	// Delay before the first retry, doubled after each attempt. Defaults to
+	// 100ms.
+	Backoff time.Duration
+	// Maximum delay between two attempts. Defaults to 2s.
+	MaxBackoff time.Duration
+	// Retryable reports whether a failed request should be retried. Defaults
+	// to IsRetryable.
+	Retryable func(error) bool
+}
+
+// WithRetryPolicy makes the stub retry failed requests according to the
+// policy.
+func WithRetryPolicy(policy RetryPolicy) StubOption {
+	return func(s *ServiceStub) {
+		s.retryPolicy = &policy
+	}
+}
+
+// IsRetryable reports whether the request may succeed if it is sent again:
+// the request timed out, the service is not registered (e.g. it is
+// restarting) or the error happened at the transport level.
+func IsRetryable(err error) bool {
+	var replyErr *ReplyError
+	if !errors.As(err, &replyErr) {
+		return true
+	}
+	switch replyErr.Type {
+	case cellaserv.Reply_Error_Timeout,
+		cellaserv.Reply_Error_NoSuchService,
+		cellaserv.Reply_Error_InvalidIdentification:
+		return true
+	}
+	return false
+}
+
+func (p *RetryPolicy) shouldRetry(attempt int, err error) bool {
+	if attempt >= p.MaxAttempts {
+		return false
+	}
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+	return retryable(err)
+}
+
+// delay returns the time to wait after the given failed attempt.
+func (p *RetryPolicy) delay(attempt int) time.Duration {
+	backoff := p.Backoff
+	if backoff == 0 {
+		backoff = defaultRetryBackoff
+	}
+	maxBackoff := p.MaxBackoff
+	if maxBackoff == 0 {
+		maxBackoff = defaultRetryMaxBackoff
+	}
+	for i := 1; i < attempt && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff
+}
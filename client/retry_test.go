@@ -0,0 +1,56 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	cellaserv "github.com/evolutek/cellaserv3-protobuf"
+	"github.com/evolutek/cellaserv3/testutil"
+)
+
+func TestIsRetryable(t *testing.T) {
+	testutil.Assert(t, IsRetryable(ErrConnectionClosed), "transport errors are retryable")
+	testutil.Assert(t, IsRetryable(&ReplyError{Type: cellaserv.Reply_Error_Timeout}), "timeouts are retryable")
+	testutil.Assert(t, !IsRetryable(&ReplyError{Type: cellaserv.Reply_Error_Custom}), "service errors are not retryable")
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{Backoff: 10 * time.Millisecond, MaxBackoff: 30 * time.Millisecond}
+	testutil.Equals(t, 10*time.Millisecond, p.delay(1))
+	testutil.Equals(t, 20*time.Millisecond, p.delay(2))
+	testutil.Equals(t, 30*time.Millisecond, p.delay(3))
+	testutil.Equals(t, 30*time.Millisecond, p.delay(10))
+}
+
+func TestServiceStubRetry(t *testing.T) {
+	withTestBroker(t, ":4201", func(clientOpts ClientOpts) {
+		// The service registers after the first request is sent
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			connService := NewClient(clientOpts)
+			dateService := connService.NewService("date", "")
+			dateService.HandleRequestFunc("time", func(context.Context, *cellaserv.Request) (interface{}, error) {
+				return time.Now(), nil
+			})
+			connService.RegisterService(dateService)
+		}()
+
+		connRequest := NewClient(clientOpts)
+
+		// Without retry, the request fails
+		_, err := NewServiceStub(connRequest, "date", "").Request("time", nil)
+		var replyErr *ReplyError
+		testutil.Assert(t, errors.As(err, &replyErr), "expected reply error, got: %v", err)
+		testutil.Equals(t, cellaserv.Reply_Error_NoSuchService, replyErr.Type)
+
+		// With retry, the request survives the missing service
+		dateStub := NewServiceStub(connRequest, "date", "", WithRetryPolicy(RetryPolicy{
+			MaxAttempts: 10,
+			Backoff:     50 * time.Millisecond,
+		}))
+		_, err = dateStub.Request("time", nil)
+		testutil.Ok(t, err)
+	})
+}
@@ -1,13 +1,30 @@
 package client
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
+	"runtime/debug"
+	"sort"
 
 	cellaserv "github.com/evolutek/cellaserv3-protobuf"
+	"github.com/evolutek/cellaserv3/broker/cellaserv/api"
+	"github.com/evolutek/cellaserv3/common"
 )
 
-type RequestHandlerFunc func(*cellaserv.Request) (interface{}, error)
+// Name of the method automatically added to services to list their methods
+const listMethodsMethod = "list_methods"
+
+// RequestHandlerFunc handles a request. The returned value is serialized in
+// the reply data with the codec of the request, or the codec of the client if
+// the requester used an unknown codec, unless it is RawData.
+//
+// The context expires when the broker gives up on the request, so that long
+// handlers can abort early.
+type RequestHandlerFunc func(ctx context.Context, req *cellaserv.Request) (interface{}, error)
+
+// RawData is returned by request handlers to reply with data sent as-is,
+// e.g. protobuf or binary sensor frames.
+type RawData []byte
 
 type EventHandlerFunc func(*cellaserv.Publish)
 
@@ -15,33 +32,102 @@ type service struct {
 	Name           string
 	Identification string
 
+	// Client the service was created by
+	client *Client
+
 	requestHandlers map[string](RequestHandlerFunc)
 	eventHandlers   map[string](EventHandlerFunc)
+	// Documentation of the methods, returned by list_methods
+	methodDocs map[string]string
+
+	// Default codec of replies
+	codec Codec
+
+	// Number of requests handled at the same time, zero to handle them in
+	// the message loop of the client
+	workers   int
+	queueSize int
+	// Requests waiting for a worker
+	queue chan func()
 }
 
 func (s *service) String() string {
 	return fmt.Sprintf("%s[%s]", s.Name, s.Identification)
 }
 
-// NewService returns an initialized Service instance
+// NewService returns an initialized Service instance. The service handles the
+// list_methods method, returning its methods and their documentation.
 func (c *Client) NewService(name string, identification string) *service {
-	return &service{
+	s := &service{
 		Name:            name,
 		Identification:  identification,
+		client:          c,
 		requestHandlers: make(map[string](RequestHandlerFunc)),
 		eventHandlers:   make(map[string](EventHandlerFunc)),
+		methodDocs:      make(map[string]string),
+		codec:           c.codec,
 	}
+	s.HandleRequestFunc(listMethodsMethod, func(context.Context, *cellaserv.Request) (interface{}, error) {
+		return s.listMethods(), nil
+	})
+	s.SetDoc(listMethodsMethod, "Returns the methods of the service.")
+	return s
 }
 
 func (s *service) HandleRequestFunc(action string, f RequestHandlerFunc) {
 	s.requestHandlers[action] = f
 }
 
+// SetDoc sets the documentation of a method, returned by list_methods.
+func (s *service) SetDoc(method string, doc string) {
+	s.methodDocs[method] = doc
+}
+
+// listMethods returns the methods of the service, sorted by name.
+func (s *service) listMethods() api.ListMethodsResponse {
+	methods := make(api.ListMethodsResponse, 0, len(s.requestHandlers))
+	for name := range s.requestHandlers {
+		methods = append(methods, api.MethodJSON{Name: name, Doc: s.methodDocs[name]})
+	}
+	sort.Slice(methods, func(i, j int) bool { return methods[i].Name < methods[j].Name })
+	return methods
+}
+
+// SetConcurrency makes the service handle up to workers requests at the same
+// time instead of one at a time, so that a slow method does not delay the
+// others. Up to queueSize requests wait for a worker, the requests received
+// while the queue is full are answered with an error. Must be called before
+// the service is registered.
+func (s *service) SetConcurrency(workers int, queueSize int) {
+	s.workers = workers
+	s.queueSize = queueSize
+}
+
+// runWorker handles the queued requests of the service until quit is closed.
+func (s *service) runWorker(quit <-chan struct{}) {
+	for {
+		select {
+		case handle := <-s.queue:
+			handle()
+		case <-quit:
+			return
+		}
+	}
+}
+
 func (s *service) HandleEventFunc(event string, f EventHandlerFunc) {
 	s.eventHandlers[event] = f
 }
 
-func (s *service) handleRequest(req *cellaserv.Request, method string) ([]byte, error) {
+// replyCodec returns the codec used to reply to the request.
+func (s *service) replyCodec(req *cellaserv.Request) Codec {
+	if codec, ok := codecFor(req); ok {
+		return codec
+	}
+	return s.codec
+}
+
+func (s *service) handleRequest(ctx context.Context, req *cellaserv.Request, method string, codec Codec) ([]byte, error) {
 	// Find handler
 	handle, ok := s.requestHandlers[method]
 	if !ok {
@@ -49,16 +135,45 @@ func (s *service) handleRequest(req *cellaserv.Request, method string) ([]byte,
 	}
 
 	// Call handler
-	reply, err := handle(req)
+	reply, err := callHandler(ctx, handle, req)
 	if err != nil {
 		return nil, err
 	}
 
-	// Marshal reply object as JSON
-	replyBytes, err := json.Marshal(reply)
-	if err != nil {
-		return nil, err
+	// Marshal reply object
+	return marshalReply(reply, codec)
+}
+
+// callHandler calls the request handler, converting a panic into a
+// *PanicError so that a bad request does not crash the service.
+func callHandler(ctx context.Context, handle RequestHandlerFunc, req *cellaserv.Request) (reply interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return handle(ctx, req)
+}
+
+// marshalReply serializes the reply value with the codec, unless it is
+// RawData.
+func marshalReply(reply interface{}, codec Codec) ([]byte, error) {
+	if raw, ok := reply.(RawData); ok {
+		return raw, nil
 	}
-	return replyBytes, nil
+	return codec.Marshal(reply)
+}
 
+// requestContext returns the context of a request handler, expiring at the
+// request deadline set by the broker and carrying the trace id of the request.
+func requestContext(req *cellaserv.Request) (context.Context, context.CancelFunc) {
+	traceId := common.GetTraceId(req)
+	if traceId == "" {
+		traceId = common.NewTraceId()
+	}
+	ctx := WithTraceId(context.Background(), traceId)
+	if deadline, ok := common.GetDeadline(req); ok {
+		return context.WithDeadline(ctx, deadline)
+	}
+	return context.WithCancel(ctx)
 }
@@ -1,10 +1,12 @@
 package client
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
+	"time"
 
 	cellaserv "github.com/evolutek/cellaserv3-protobuf"
+	"github.com/evolutek/cellaserv3/common"
 )
 
 type ServiceStub struct {
@@ -12,27 +14,106 @@ type ServiceStub struct {
 	identification string
 
 	client *Client
+
+	// Retry policy for failed requests, nil to disable retries
+	retryPolicy *RetryPolicy
+	// Codec of the request data, nil to use the codec of the client
+	requestCodec Codec
+	// Priority of the requests
+	priority common.Priority
+	// Cache of the replies, nil to disable caching
+	cache *replyCache
 }
 
+// StubOption configures a ServiceStub.
+type StubOption func(*ServiceStub)
+
 func (s *ServiceStub) String() string {
 	return fmt.Sprintf("%s[%s]", s.name, s.identification)
 }
 
 func (s *ServiceStub) sendRequest(req *cellaserv.Request) ([]byte, error) {
-	s.client.logger.Debugf("Sending request %s[%s].%s(%s)", req.ServiceName, req.ServiceIdentification, req.Method, req.Data)
+	reply, err := s.sendRequestForReply(req)
+	if err != nil {
+		return nil, err
+	}
+	return reply.GetData(), nil
+}
+
+// sendRequestForReply returns the cached reply to the request, or sends the
+// request.
+func (s *ServiceStub) sendRequestForReply(req *cellaserv.Request) (*cellaserv.Reply, error) {
+	if s.cache == nil {
+		return s.sendRequestRetrying(req)
+	}
+	if reply, ok := s.cache.get(req); ok {
+		return reply, nil
+	}
+	reply, err := s.sendRequestRetrying(req)
+	if err != nil {
+		return nil, err
+	}
+	s.cache.put(req, reply)
+	return reply, nil
+}
+
+// sendRequestRetrying sends the request, retrying according to the retry
+// policy, and returns the successful reply.
+func (s *ServiceStub) sendRequestRetrying(req *cellaserv.Request) (*cellaserv.Reply, error) {
+	common.SetPriority(req, s.priority)
+	if common.GetTraceId(req) == "" {
+		common.SetTraceId(req, common.NewTraceId())
+	}
+	s.client.logger.Debugf("Sending request %s[%s].%s(%s) [trace %s]", req.ServiceName, req.ServiceIdentification, req.Method, req.Data, common.GetTraceId(req))
+	deadline, _ := common.GetDeadline(req)
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		// Each attempt starts from the deadline set by the requester
+		common.SetDeadline(req, deadline)
+		var reply *cellaserv.Reply
+		reply, err = s.sendRequestOnce(req)
+		if err == nil {
+			return reply, nil
+		}
+		if s.retryPolicy == nil || !s.retryPolicy.shouldRetry(attempt, err) {
+			break
+		}
+		delay := s.retryPolicy.delay(attempt)
+		if !deadline.IsZero() && time.Now().Add(delay).After(deadline) {
+			break
+		}
+		s.client.logger.Warnf("Request %s.%s failed, retrying in %s: %s", s, req.Method, delay, err)
+		time.Sleep(delay)
+	}
+	return nil, err
+}
 
-	reply := s.client.sendRequestWaitForReply(req)
+func (s *ServiceStub) sendRequestOnce(req *cellaserv.Request) (*cellaserv.Reply, error) {
+	reply, err := s.client.invokeRequest(req)
+	if err != nil {
+		return nil, err
+	}
 
 	// Check for errors
 	replyError := reply.GetError()
 	if replyError != nil {
 		s.client.logger.Errorf("Received reply error: %s", replyError.String())
-		return nil, fmt.Errorf(replyError.String())
+		return nil, &ReplyError{Type: replyError.GetType(), What: replyError.GetWhat()}
 	}
 
-	return reply.GetData(), nil
+	return reply, nil
 }
 
+// codec returns the codec used to serialize request data.
+func (s *ServiceStub) codec() Codec {
+	if s.requestCodec != nil {
+		return s.requestCodec
+	}
+	return s.client.codec
+}
+
+// RequestNoData sends a request without data.
 func (s *ServiceStub) RequestNoData(method string) ([]byte, error) {
 	// Create Request
 	req := &cellaserv.Request{
@@ -45,11 +126,110 @@ func (s *ServiceStub) RequestNoData(method string) ([]byte, error) {
 	return s.sendRequest(req)
 }
 
+// Request sends a request with data serialized with the codec of the stub,
+// JSON by default. Use RequestRaw for data that must be sent as-is.
 func (s *ServiceStub) Request(method string, data interface{}) ([]byte, error) {
+	req, err := s.newRequest(method, data)
+	if err != nil {
+		return nil, err
+	}
+	return s.sendRequest(req)
+}
+
+// Call sends a request with args serialized with the codec of the stub, and
+// decodes the reply data in reply, with the codec selected by the service.
+// reply is left untouched if it is nil or the reply has no data.
+func (s *ServiceStub) Call(method string, args interface{}, reply interface{}) error {
+	req, err := s.newRequest(method, args)
+	if err != nil {
+		return err
+	}
+	rep, err := s.sendRequestForReply(req)
+	if err != nil {
+		return err
+	}
+	return decodeReply(rep, reply)
+}
+
+// RequestContext is like Request, with the deadline of ctx sent along with the
+// request: cellaserv and the service give up on the request after it. It
+// returns ctx.Err() if ctx is done before the reply is received. The trace id
+// of ctx is sent along with the request: pass the context of the request
+// handler to follow an action across services.
+func (s *ServiceStub) RequestContext(ctx context.Context, method string, data interface{}) ([]byte, error) {
+	req, err := s.newRequest(method, data)
+	if err != nil {
+		return nil, err
+	}
+	rep, err := s.sendRequestContext(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return rep.GetData(), nil
+}
+
+// CallContext is like Call, with the deadline and cancellation of ctx, see
+// RequestContext.
+func (s *ServiceStub) CallContext(ctx context.Context, method string, args interface{}, reply interface{}) error {
+	req, err := s.newRequest(method, args)
+	if err != nil {
+		return err
+	}
+	rep, err := s.sendRequestContext(ctx, req)
+	if err != nil {
+		return err
+	}
+	return decodeReply(rep, reply)
+}
+
+// sendRequestContext sends the request with the deadline of ctx, and stops
+// waiting for the reply when ctx is done.
+func (s *ServiceStub) sendRequestContext(ctx context.Context, req *cellaserv.Request) (*cellaserv.Reply, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		common.SetDeadline(req, deadline)
+	}
+	common.SetTraceId(req, TraceId(ctx))
+
+	type result struct {
+		reply *cellaserv.Reply
+		err   error
+	}
+	resultCh := make(chan result, 1)
+	go func() {
+		reply, err := s.sendRequestForReply(req)
+		resultCh <- result{reply, err}
+	}()
+
+	select {
+	case res := <-resultCh:
+		return res.reply, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// decodeReply decodes the reply data in reply, with the codec selected by the
+// service.
+func decodeReply(rep *cellaserv.Reply, reply interface{}) error {
+	if reply == nil || len(rep.GetData()) == 0 {
+		return nil
+	}
+	codec, ok := codecFor(rep)
+	if !ok {
+		return fmt.Errorf("Unknown reply content type: %q", common.GetContentType(rep))
+	}
+	return codec.Unmarshal(rep.GetData(), reply)
+}
+
+func (s *ServiceStub) newRequest(method string, data interface{}) (*cellaserv.Request, error) {
 	// Serialize request payload
-	dataBytes, err := json.Marshal(data)
+	codec := s.codec()
+	dataBytes, err := codec.Marshal(data)
 	if err != nil {
-		panic(fmt.Sprintf("Could not marshal to JSON: %v", data))
+		return nil, fmt.Errorf("Could not marshal request data: %s", err)
 	}
 
 	// Create Request
@@ -60,10 +240,11 @@ func (s *ServiceStub) Request(method string, data interface{}) ([]byte, error) {
 		Method:                method,
 		// Id set by client
 	}
-
-	return s.sendRequest(req)
+	common.SetContentType(req, codec.ContentType())
+	return req, nil
 }
 
+// RequestRaw sends a request with data sent as-is.
 func (s *ServiceStub) RequestRaw(method string, dataBytes []byte) ([]byte, error) {
 	// Create Request
 	req := &cellaserv.Request{
@@ -77,10 +258,29 @@ func (s *ServiceStub) RequestRaw(method string, dataBytes []byte) ([]byte, error
 	return s.sendRequest(req)
 }
 
-func NewServiceStub(c *Client, name string, identification string) *ServiceStub {
-	return &ServiceStub{
+// WithCodec sets the codec used to serialize the request data of the stub.
+func WithCodec(codec Codec) StubOption {
+	return func(s *ServiceStub) {
+		s.requestCodec = codec
+	}
+}
+
+// WithPriority sets the priority of the requests of the stub, e.g.
+// common.PriorityHigh for motion control.
+func WithPriority(priority common.Priority) StubOption {
+	return func(s *ServiceStub) {
+		s.priority = priority
+	}
+}
+
+func NewServiceStub(c *Client, name string, identification string, opts ...StubOption) *ServiceStub {
+	s := &ServiceStub{
 		name:           name,
 		identification: identification,
 		client:         c,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
@@ -2,58 +2,236 @@ package client
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
 	"testing"
 	"time"
 
 	cellaserv "github.com/evolutek/cellaserv3-protobuf"
-	"github.com/evolutek/cellaserv3/broker"
-	"github.com/evolutek/cellaserv3/common"
+	"github.com/evolutek/cellaserv3/broker/cellaserv/api"
+	"github.com/evolutek/cellaserv3/testutil"
 )
 
 func TestServiceRequest(t *testing.T) {
-	ctxBroker, cancelBroker := context.WithCancel(context.Background())
+	withTestBroker(t, ":4201", func(clientOpts ClientOpts) {
+		// Open connection
+		connService := NewClient(clientOpts)
+		// Prepare service for registration
+		dateService := connService.NewService("date", "")
+		// Handle "time" request
+		dateService.HandleRequestFunc("time", func(context.Context, *cellaserv.Request) (interface{}, error) {
+			return time.Now(), nil
+		})
+		// Register the service
+		connService.RegisterService(dateService)
 
-	brokerOptions := broker.Options{
-		ListenAddress: ":4201",
-	}
-	broker := broker.New(brokerOptions, common.NewLogger("test"))
+		time.Sleep(50 * time.Millisecond)
 
-	go func() {
-		err := broker.Run(ctxBroker)
-		if err != nil {
-			t.Fatalf("Could not start broker: %s", err)
+		// Create service client connection
+		connRequest := NewClient(clientOpts)
+		dateServiceStub := NewServiceStub(connRequest, "date", "")
+
+		// Test valid method
+		dateServiceStub.Request("time", nil)
+
+		// Testt invalid method
+		_, err := dateServiceStub.Request("foobarlol", nil)
+		if err == nil {
+			t.Errorf("Did not return error on non-existing method")
 		}
-	}()
+	})
+}
 
-	time.Sleep(50 * time.Millisecond)
+func TestListMethods(t *testing.T) {
+	_, conn := net.Pipe()
+	c := newClient(conn, ClientOpts{})
+	defer c.Close()
 
-	// Open connection
-	clientOpts := ClientOpts{CellaservAddr: ":4201"}
-	connService := NewClient(clientOpts)
-	// Prepare service for registration
-	dateService := connService.NewService("date", "")
-	// Handle "time" request
-	dateService.HandleRequestFunc("time", func(_ *cellaserv.Request) (interface{}, error) {
-		return time.Now(), nil
+	s := c.NewService("date", "")
+	s.HandleRequestFunc("time", func(context.Context, *cellaserv.Request) (interface{}, error) {
+		return nil, nil
+	})
+	s.SetDoc("time", "Returns the current time.")
+	s.HandleRequestFunc("epoch", func(context.Context, *cellaserv.Request) (interface{}, error) {
+		return nil, nil
 	})
-	// Register the service
-	connService.RegisterService(dateService)
 
-	time.Sleep(50 * time.Millisecond)
+	data, err := s.handleRequest(context.Background(), &cellaserv.Request{}, "list_methods", JSONCodec)
+	testutil.Ok(t, err)
 
-	// Create service client connection
-	connRequest := NewClient(clientOpts)
-	dateServiceStub := NewServiceStub(connRequest, "date", "")
+	var methods api.ListMethodsResponse
+	testutil.Ok(t, json.Unmarshal(data, &methods))
+	testutil.Equals(t, api.ListMethodsResponse{
+		{Name: "epoch"},
+		{Name: "list_methods", Doc: "Returns the methods of the service."},
+		{Name: "time", Doc: "Returns the current time."},
+	}, methods)
+}
+
+func TestRequestContextDeadline(t *testing.T) {
+	withTestBroker(t, ":4201", func(opts ClientOpts) {
+		c := NewClient(opts)
+		defer c.Close()
+
+		s := c.NewService("deadline", "")
+		s.HandleRequestFunc("get", func(ctx context.Context, _ *cellaserv.Request) (interface{}, error) {
+			deadline, ok := ctx.Deadline()
+			if !ok {
+				return nil, fmt.Errorf("No deadline")
+			}
+			return time.Until(deadline).Seconds(), nil
+		})
+		c.RegisterService(s)
+		time.Sleep(50 * time.Millisecond)
 
-	// Test valid method
-	dateServiceStub.Request("time", nil)
+		var remaining float64
+		err := NewServiceStub(c, "deadline", "").Call("get", nil, &remaining)
+		testutil.Ok(t, err)
+		// The broker default timeout is one hour
+		testutil.Assert(t, remaining > 3590 && remaining <= 3600, "unexpected remaining time: %f", remaining)
+	})
+}
+
+func TestCallContextDeadline(t *testing.T) {
+	withTestBroker(t, ":4201", func(opts ClientOpts) {
+		srvc := NewClient(opts)
+		defer srvc.Close()
+
+		handlerDeadline := make(chan time.Time, 1)
+		s := srvc.NewService("slow", "")
+		s.HandleRequestFunc("wait", func(ctx context.Context, _ *cellaserv.Request) (interface{}, error) {
+			deadline, _ := ctx.Deadline()
+			handlerDeadline <- deadline
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+		srvc.RegisterService(s)
+		time.Sleep(50 * time.Millisecond)
+
+		c := NewClient(opts)
+		defer c.Close()
+		stub := NewServiceStub(c, "slow", "")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		expected, _ := ctx.Deadline()
+		start := time.Now()
+		err := stub.CallContext(ctx, "wait", nil, nil)
+		testutil.NotOk(t, err, "request did not time out")
+		testutil.Assert(t, time.Since(start) < time.Second, "request timed out late: %s", time.Since(start))
+
+		// The handler sees the deadline of the requester
+		select {
+		case deadline := <-handlerDeadline:
+			testutil.Assert(t, deadline.Equal(expected), "handler deadline %s, expected %s", deadline, expected)
+		case <-time.After(time.Second):
+			t.Fatal("request not handled")
+		}
+
+		// Canceled contexts are not sent
+		cancel()
+		_, err = stub.RequestContext(ctx, "wait", nil)
+		testutil.Equals(t, context.Canceled, err)
+	})
+}
 
-	// Testt invalid method
-	_, err := dateServiceStub.Request("foobarlol", nil)
-	if err == nil {
-		t.Errorf("Did not return error on non-existing method")
-	}
+func TestUnregisterService(t *testing.T) {
+	withTestBroker(t, ":4201", func(opts ClientOpts) {
+		c := NewClient(opts)
+		defer c.Close()
 
-	// Shutdown cellaserv
-	cancelBroker()
+		date := c.NewService("date", "")
+		c.RegisterService(date)
+		echo := c.NewService("echo", "")
+		c.RegisterService(echo)
+		time.Sleep(50 * time.Millisecond)
+
+		testutil.Ok(t, c.UnregisterService(date))
+		testutil.NotOk(t, c.UnregisterService(date), "service unregistered twice")
+		time.Sleep(50 * time.Millisecond)
+
+		_, err := NewServiceStub(c, "date", "").Request("list_methods", nil)
+		testutil.NotOk(t, err, "request to an unregistered service succeeded")
+		_, err = NewServiceStub(c, "echo", "").Request("list_methods", nil)
+		testutil.Ok(t, err)
+	})
+}
+
+func TestHandlerPanic(t *testing.T) {
+	withTestBroker(t, ":4201", func(opts ClientOpts) {
+		srvc := NewClient(opts)
+		defer srvc.Close()
+		s := srvc.NewService("crash", "")
+		s.HandleRequestFunc("boom", func(context.Context, *cellaserv.Request) (interface{}, error) {
+			panic("boom")
+		})
+		srvc.RegisterService(s)
+
+		c := NewClient(opts)
+		defer c.Close()
+		panics, cancel, err := c.SubscribeChan("log.crash.panic")
+		testutil.Ok(t, err)
+		defer cancel()
+		time.Sleep(50 * time.Millisecond)
+
+		_, err = NewServiceStub(c, "crash", "").RequestNoData("boom")
+		var replyErr *ReplyError
+		testutil.Assert(t, errors.As(err, &replyErr), "Expected a reply error, got: %v", err)
+		testutil.Assert(t, strings.HasPrefix(replyErr.What, "Panic: boom\n"), "Invalid error: %s", replyErr.What)
+
+		select {
+		case e := <-panics:
+			var info api.HandlerPanicJSON
+			testutil.Ok(t, json.Unmarshal(e.Data, &info))
+			testutil.Equals(t, "boom", info.Method)
+			testutil.Equals(t, "boom", info.Panic)
+		case <-time.After(time.Second):
+			t.Fatal("Panic event not received")
+		}
+
+		// The service still handles requests
+		_, err = NewServiceStub(c, "crash", "").RequestNoData("list_methods")
+		testutil.Ok(t, err)
+	})
+}
+
+func TestServiceConcurrency(t *testing.T) {
+	withTestBroker(t, ":4201", func(opts ClientOpts) {
+		srvc := NewClient(opts)
+		defer srvc.Close()
+		s := srvc.NewService("map", "")
+		s.SetConcurrency(2, 0)
+		release := make(chan struct{})
+		s.HandleRequestFunc("get_map", func(context.Context, *cellaserv.Request) (interface{}, error) {
+			<-release
+			return "map", nil
+		})
+		s.HandleRequestFunc("get_position", func(context.Context, *cellaserv.Request) (interface{}, error) {
+			return "position", nil
+		})
+		srvc.RegisterService(s)
+		time.Sleep(50 * time.Millisecond)
+
+		c := NewClient(opts)
+		defer c.Close()
+		stub := NewServiceStub(c, "map", "")
+
+		slow := make(chan error)
+		go func() {
+			_, err := stub.RequestNoData("get_map")
+			slow <- err
+		}()
+		time.Sleep(50 * time.Millisecond)
+
+		// Answered while get_map is in progress
+		resp, err := stub.RequestNoData("get_position")
+		testutil.Ok(t, err)
+		testutil.Equals(t, `"position"`, string(resp))
+
+		close(release)
+		testutil.Ok(t, <-slow)
+	})
 }
@@ -0,0 +1,99 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	cellaserv "github.com/evolutek/cellaserv3-protobuf"
+	"github.com/evolutek/cellaserv3/common"
+	"github.com/golang/protobuf/proto"
+)
+
+// PartialReplyHandler receives the data of a partial reply to a streamed
+// request. It is called from the receive loop of the client and must not
+// block.
+type PartialReplyHandler func(data []byte)
+
+type partialReplierKey struct{}
+
+// partialReplier sends the partial replies of the request being handled.
+type partialReplier struct {
+	client *Client
+	req    *cellaserv.Request
+	codec  Codec
+}
+
+func withPartialReplier(ctx context.Context, c *Client, req *cellaserv.Request, codec Codec) context.Context {
+	return context.WithValue(ctx, partialReplierKey{}, &partialReplier{client: c, req: req, codec: codec})
+}
+
+// SendPartialReply sends a partial reply to the request handled with ctx, e.g.
+// a chunk of a LIDAR scan or the progress of a trajectory. The data is
+// serialized like the value returned by the handler. The value returned by
+// the handler is the final reply of the request.
+//
+// Requesters that do not use RequestStream ignore partial replies.
+func SendPartialReply(ctx context.Context, data interface{}) error {
+	r, ok := ctx.Value(partialReplierKey{}).(*partialReplier)
+	if !ok {
+		return fmt.Errorf("Context of a request handler required to send a partial reply")
+	}
+
+	dataBytes, err := marshalReply(data, r.codec)
+	if err != nil {
+		return fmt.Errorf("Could not marshal partial reply: %s", err)
+	}
+
+	rep := &cellaserv.Reply{Id: r.req.Id, Data: dataBytes}
+	common.SetContentType(rep, r.codec.ContentType())
+	common.SetPartialReply(rep, true)
+	repBytes, err := proto.Marshal(rep)
+	if err != nil {
+		return fmt.Errorf("Could not marshal partial reply: %s", err)
+	}
+
+	msg := &cellaserv.Message{Type: cellaserv.Message_Reply, Content: repBytes}
+	return common.SendMessage(r.client.conn, msg)
+}
+
+// dispatchPartialReply calls the partial reply handler of the streamed
+// request.
+func (c *Client) dispatchPartialReply(rep *cellaserv.Reply) error {
+	c.mtx.RLock()
+	partial, ok := c.partialReplies[rep.GetId()]
+	c.mtx.RUnlock()
+	if !ok {
+		c.logger.Debugf("Ignoring partial reply to request %d", rep.GetId())
+		return nil
+	}
+	partial(rep.GetData())
+	return nil
+}
+
+// RequestStream sends a request like Request. partial is called with the data
+// of each partial reply sent by the service, then the data of the final reply
+// is returned.
+func (s *ServiceStub) RequestStream(method string, data interface{}, partial PartialReplyHandler) ([]byte, error) {
+	req, err := s.newRequest(method, data)
+	if err != nil {
+		return nil, err
+	}
+
+	c := s.client
+	c.mtx.Lock()
+	c.streams[req] = partial
+	c.mtx.Unlock()
+
+	defer func() {
+		c.mtx.Lock()
+		delete(c.streams, req)
+		c.mtx.Unlock()
+	}()
+
+	// Streamed replies are never cached
+	reply, err := s.sendRequestRetrying(req)
+	if err != nil {
+		return nil, err
+	}
+	return reply.GetData(), nil
+}
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	cellaserv "github.com/evolutek/cellaserv3-protobuf"
+	"github.com/evolutek/cellaserv3/testutil"
+)
+
+func TestRequestStream(t *testing.T) {
+	withTestBroker(t, ":4201", func(opts ClientOpts) {
+		srvc := NewClient(opts)
+		defer srvc.Close()
+		s := srvc.NewService("lidar", "")
+		s.HandleRequestFunc("scan", func(ctx context.Context, _ *cellaserv.Request) (interface{}, error) {
+			for i := 0; i < 3; i++ {
+				if err := SendPartialReply(ctx, i); err != nil {
+					return nil, err
+				}
+			}
+			return "done", nil
+		})
+		srvc.RegisterService(s)
+		time.Sleep(50 * time.Millisecond)
+
+		c := NewClient(opts)
+		defer c.Close()
+		stub := NewServiceStub(c, "lidar", "")
+
+		var chunks []int
+		resp, err := stub.RequestStream("scan", nil, func(data []byte) {
+			var i int
+			if err := json.Unmarshal(data, &i); err != nil {
+				t.Errorf("Invalid partial reply: %s", err)
+			}
+			chunks = append(chunks, i)
+		})
+		testutil.Ok(t, err)
+		testutil.Equals(t, `"done"`, string(resp))
+		testutil.Equals(t, []int{0, 1, 2}, chunks)
+
+		// Partial replies are ignored by plain requests
+		resp, err = stub.Request("scan", nil)
+		testutil.Ok(t, err)
+		testutil.Equals(t, `"done"`, string(resp))
+	})
+}
+
+func TestSendPartialReplyOutsideHandler(t *testing.T) {
+	err := SendPartialReply(context.Background(), 42)
+	testutil.NotOk(t, err, "partial reply sent without a request")
+}
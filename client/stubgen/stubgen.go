@@ -0,0 +1,213 @@
+// Package stubgen generates typed Go stubs for cellaserv services.
+//
+// A service is described in JSON:
+//
+//	{
+//	  "package": "trajman",
+//	  "service": "trajman",
+//	  "types": [
+//	    {"name": "Position", "fields": [
+//	      {"name": "X", "type": "float64", "json": "x"},
+//	      {"name": "Y", "type": "float64", "json": "y"}
+//	    ]}
+//	  ],
+//	  "methods": [
+//	    {"name": "goto_xy", "request": "Position", "doc": "Moves the robot."},
+//	    {"name": "get_position", "response": "Position"}
+//	  ]
+//	}
+//
+// The generated stub wraps client.ServiceStub, marshals the request
+// argument and unmarshals the reply data into the response type.
+package stubgen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"io"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// Field is a field of a generated request or response struct.
+type Field struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Key used in the JSON serialization of the field, defaults to Name
+	JSON string `json:"json"`
+}
+
+// Type is a request or response struct to generate.
+type Type struct {
+	Name   string  `json:"name"`
+	Doc    string  `json:"doc"`
+	Fields []Field `json:"fields"`
+}
+
+// Method is a method of the service.
+type Method struct {
+	// Name of the cellaserv method, e.g. "get_position"
+	Name string `json:"name"`
+	Doc  string `json:"doc"`
+	// Go type of the request argument, empty if the method takes none
+	Request string `json:"request"`
+	// Go type of the reply data, empty if the reply is ignored
+	Response string `json:"response"`
+}
+
+// Service is the description of a cellaserv service.
+type Service struct {
+	// Package of the generated file
+	Package string `json:"package"`
+	// Name of the cellaserv service
+	Service string `json:"service"`
+	// Additional imports needed by the request and response types
+	Imports []string `json:"imports"`
+	Types   []Type   `json:"types"`
+	Methods []Method `json:"methods"`
+}
+
+// Parse reads a JSON service description.
+func Parse(r io.Reader) (*Service, error) {
+	var s Service
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("Could not parse service description: %s", err)
+	}
+	if s.Package == "" {
+		return nil, fmt.Errorf("Missing package name in service description")
+	}
+	if s.Service == "" {
+		return nil, fmt.Errorf("Missing service name in service description")
+	}
+	for _, m := range s.Methods {
+		if m.Name == "" {
+			return nil, fmt.Errorf("Missing method name in service %s", s.Service)
+		}
+	}
+	return &s, nil
+}
+
+// GoName converts a snake_case cellaserv name to an exported Go identifier,
+// e.g. "get_position" becomes "GetPosition".
+func GoName(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if r == '_' || r == '-' || r == '.' {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+var stubTemplate = template.Must(template.New("stub").Funcs(template.FuncMap{
+	"goName": GoName,
+	"jsonKey": func(f Field) string {
+		if f.JSON != "" {
+			return f.JSON
+		}
+		return f.Name
+	},
+}).Parse(`// Code generated by cellaserv-stubgen. DO NOT EDIT.
+
+package {{ .Package }}
+
+import (
+{{- if .DecodesReplies }}
+	"encoding/json"
+	"fmt"
+{{- end }}
+{{- range .Imports }}
+	"{{ . }}"
+{{- end }}
+
+	"github.com/evolutek/cellaserv3/client"
+)
+
+{{ range .Types }}
+{{ if .Doc }}// {{ .Doc }}
+{{ end -}}
+type {{ .Name }} struct {
+{{- range .Fields }}
+	{{ .Name }} {{ .Type }} ` + "`json:\"{{ jsonKey . }}\"`" + `
+{{- end }}
+}
+{{ end }}
+{{ $stub := printf "%sStub" (goName .Service) -}}
+// {{ $stub }} is a typed stub of the {{ .Service }} service.
+type {{ $stub }} struct {
+	stub *client.ServiceStub
+}
+
+// New{{ $stub }} returns a stub of the {{ .Service }} service with the given
+// identification.
+func New{{ $stub }}(c *client.Client, identification string) *{{ $stub }} {
+	return &{{ $stub }}{stub: client.NewServiceStub(c, "{{ .Service }}", identification)}
+}
+
+// Stub returns the untyped service stub.
+func (s *{{ $stub }}) Stub() *client.ServiceStub {
+	return s.stub
+}
+{{ $service := .Service }}
+{{- range .Methods }}
+{{ if .Doc }}// {{ goName .Name }} {{ .Doc }}
+{{ else }}// {{ goName .Name }} calls {{ $service }}.{{ .Name }}().
+{{ end -}}
+func (s *{{ $stub }}) {{ goName .Name }}({{ if .Request }}args {{ .Request }}{{ end }}) ({{ if .Response }}{{ .Response }}, {{ end }}error) {
+{{- if .Response }}
+	var resp {{ .Response }}
+{{- end }}
+{{- if .Request }}
+	{{ if .Response }}respBytes{{ else }}_{{ end }}, err := s.stub.Request("{{ .Name }}", args)
+{{- else }}
+	{{ if .Response }}respBytes{{ else }}_{{ end }}, err := s.stub.RequestNoData("{{ .Name }}")
+{{- end }}
+	if err != nil {
+		return {{ if .Response }}resp, {{ end }}err
+	}
+{{- if .Response }}
+	if err := json.Unmarshal(respBytes, &resp); err != nil {
+		return resp, fmt.Errorf("Could not unmarshal reply of {{ $service }}.{{ .Name }}(): %w", err)
+	}
+	return resp, nil
+{{- else }}
+	return nil
+{{- end }}
+}
+{{ end }}`))
+
+// DecodesReplies returns whether any method of the service has reply data to
+// unmarshal.
+func (s *Service) DecodesReplies() bool {
+	for _, m := range s.Methods {
+		if m.Response != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Generate returns the formatted Go source of the typed stub.
+func Generate(s *Service) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := stubTemplate.Execute(&buf, s); err != nil {
+		return nil, fmt.Errorf("Could not generate stub: %s", err)
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("Could not format generated stub: %s", err)
+	}
+	return src, nil
+}
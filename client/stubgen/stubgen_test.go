@@ -0,0 +1,65 @@
+package stubgen
+
+import (
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+
+	"github.com/evolutek/cellaserv3/testutil"
+)
+
+const trajmanDescription = `{
+  "package": "trajman",
+  "service": "trajman",
+  "types": [
+    {"name": "Position", "fields": [
+      {"name": "X", "type": "float64", "json": "x"},
+      {"name": "Y", "type": "float64", "json": "y"}
+    ]}
+  ],
+  "methods": [
+    {"name": "goto_xy", "request": "Position", "doc": "moves the robot."},
+    {"name": "get_position", "response": "Position"},
+    {"name": "stop"}
+  ]
+}`
+
+func TestGoName(t *testing.T) {
+	testutil.Equals(t, "GetPosition", GoName("get_position"))
+	testutil.Equals(t, "Ax12", GoName("ax12"))
+	testutil.Equals(t, "LogCellaservNewClient", GoName("log.cellaserv.new-client"))
+}
+
+func TestParseInvalid(t *testing.T) {
+	_, err := Parse(strings.NewReader(`{"service": "trajman"}`))
+	testutil.NotOk(t, err, "missing package")
+
+	_, err = Parse(strings.NewReader(`{"package": "p", "service": "s", "foo": 1}`))
+	testutil.NotOk(t, err, "unknown field")
+}
+
+func TestGenerate(t *testing.T) {
+	service, err := Parse(strings.NewReader(trajmanDescription))
+	testutil.Ok(t, err)
+
+	src, err := Generate(service)
+	testutil.Ok(t, err)
+
+	// The generated code is valid Go
+	_, err = parser.ParseFile(token.NewFileSet(), "trajman_stub.go", src, 0)
+	testutil.Ok(t, err)
+
+	for _, expected := range []string{
+		"package trajman",
+		"type Position struct",
+		"X float64 `json:\"x\"`",
+		"func NewTrajmanStub(c *client.Client, identification string) *TrajmanStub",
+		"func (s *TrajmanStub) GotoXy(args Position) error",
+		"func (s *TrajmanStub) GetPosition() (Position, error)",
+		"func (s *TrajmanStub) Stop() error",
+		`s.stub.RequestNoData("stop")`,
+	} {
+		testutil.Assert(t, strings.Contains(string(src), expected), "generated stub does not contain %q:\n%s", expected, src)
+	}
+}
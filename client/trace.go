@@ -0,0 +1,21 @@
+package client
+
+import "context"
+
+type traceIdKey struct{}
+
+// WithTraceId returns a copy of ctx carrying the trace id. The trace id is
+// sent along with the requests and publishes made with ctx, e.g. with
+// ServiceStub.RequestContext or Client.PublishContext, so that a single robot
+// action can be followed across services in the logs.
+//
+// The context of a request handler carries the trace id of the request.
+func WithTraceId(ctx context.Context, traceId string) context.Context {
+	return context.WithValue(ctx, traceIdKey{}, traceId)
+}
+
+// TraceId returns the trace id carried by ctx, or the empty string.
+func TraceId(ctx context.Context) string {
+	traceId, _ := ctx.Value(traceIdKey{}).(string)
+	return traceId
+}
@@ -0,0 +1,64 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cellaserv "github.com/evolutek/cellaserv3-protobuf"
+	"github.com/evolutek/cellaserv3/testutil"
+)
+
+func TestTracePropagation(t *testing.T) {
+	withTestBroker(t, ":4201", func(opts ClientOpts) {
+		srvc := NewClient(opts)
+		defer srvc.Close()
+
+		traces := make(chan string, 2)
+		motor := srvc.NewService("motor", "")
+		motor.HandleRequestFunc("move", func(ctx context.Context, _ *cellaserv.Request) (interface{}, error) {
+			traces <- TraceId(ctx)
+			return nil, nil
+		})
+		srvc.RegisterService(motor)
+
+		// The ai service forwards the action to the motor and publishes it
+		ai := srvc.NewService("ai", "")
+		aiMotor := NewServiceStub(srvc, "motor", "")
+		ai.HandleRequestFunc("go", func(ctx context.Context, _ *cellaserv.Request) (interface{}, error) {
+			traces <- TraceId(ctx)
+			if _, err := aiMotor.RequestContext(ctx, "move", nil); err != nil {
+				return nil, err
+			}
+			return nil, srvc.PublishContext(ctx, "ai.moved", nil)
+		})
+		srvc.RegisterService(ai)
+
+		c := NewClient(opts)
+		defer c.Close()
+		events, cancel, err := c.SubscribeChan("ai.moved")
+		testutil.Ok(t, err)
+		defer cancel()
+		time.Sleep(50 * time.Millisecond)
+
+		ctx := WithTraceId(context.Background(), "action-42")
+		_, err = NewServiceStub(c, "ai", "").RequestContext(ctx, "go", nil)
+		testutil.Ok(t, err)
+
+		testutil.Equals(t, "action-42", <-traces)
+		testutil.Equals(t, "action-42", <-traces)
+		select {
+		case e := <-events:
+			testutil.Equals(t, "action-42", e.TraceId)
+		case <-time.After(time.Second):
+			t.Fatal("Event not received")
+		}
+
+		// Requests without trace id get a new one
+		_, err = NewServiceStub(c, "ai", "").Request("go", nil)
+		testutil.Ok(t, err)
+		traceId := <-traces
+		testutil.Assert(t, traceId != "" && traceId != "action-42", "Invalid trace id: %q", traceId)
+		testutil.Equals(t, traceId, <-traces)
+	})
+}
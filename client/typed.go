@@ -0,0 +1,41 @@
+//go:build go1.18
+
+package client
+
+// TypedEvent pairs an event name with the type of its data, so that
+// publishers and subscribers agree on the data at compile time:
+//
+//	var EmergencyStop = client.NewTypedEvent[StopReason]("emergency_stop")
+//
+//	client.PublishTyped(c, EmergencyStop, StopReason{Source: "lidar"})
+//	client.OnEvent(c, EmergencyStop, func(reason StopReason) { ... })
+type TypedEvent[T any] struct {
+	// Name of the event. Subscriptions accept event patterns.
+	Name string
+}
+
+// NewTypedEvent returns the typed event with the given name.
+func NewTypedEvent[T any](name string) TypedEvent[T] {
+	return TypedEvent[T]{Name: name}
+}
+
+// PublishTyped sends the typed event, with data serialized with the codec of
+// the client.
+func PublishTyped[T any](c *Client, event TypedEvent[T], data T) error {
+	return c.Publish(event.Name, data)
+}
+
+// OnEvent subscribes to the typed event. The data of the events is decoded
+// with the codec selected by the publisher. Events whose data cannot be
+// decoded are logged and dropped.
+func OnEvent[T any](c *Client, event TypedEvent[T], handler func(data T)) (SubscriptionId, error) {
+	return c.subscribe(event.Name, func(e Event) bool {
+		var data T
+		if err := e.Unmarshal(&data); err != nil {
+			c.logger.Errorf("Could not unmarshal event %q: %s", e.Name, err)
+			return false
+		}
+		handler(data)
+		return false
+	}, nil)
+}
@@ -0,0 +1,78 @@
+//go:build go1.18
+
+package client
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	cellaserv "github.com/evolutek/cellaserv3-protobuf"
+	"github.com/evolutek/cellaserv3/common"
+	"github.com/evolutek/cellaserv3/testutil"
+	"github.com/golang/protobuf/proto"
+)
+
+type stopReason struct {
+	Source string
+}
+
+var emergencyStop = NewTypedEvent[stopReason]("emergency_stop")
+
+func TestTypedEvents(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+
+	c := newClient(client, ClientOpts{})
+	defer c.Close()
+
+	received := make(chan stopReason, 1)
+	subscribed := make(chan struct{})
+	go func() {
+		closed, _, msg, err := common.RecvMessage(server)
+		if closed || err != nil {
+			t.Error("Could not receive subscribe:", err)
+			return
+		}
+		testutil.Equals(t, cellaserv.Message_Subscribe, msg.GetType())
+		close(subscribed)
+	}()
+	_, err := OnEvent(c, emergencyStop, func(reason stopReason) {
+		received <- reason
+	})
+	testutil.Ok(t, err)
+	<-subscribed
+
+	// The data is decoded with the codec of the publisher
+	data, err := MsgpackCodec.Marshal(stopReason{Source: "lidar"})
+	testutil.Ok(t, err)
+	pub := &cellaserv.Publish{Event: emergencyStop.Name, Data: data}
+	common.SetContentType(pub, MsgpackCodec.ContentType())
+	pubBytes, err := proto.Marshal(pub)
+	testutil.Ok(t, err)
+	go common.SendMessage(server, &cellaserv.Message{Type: cellaserv.Message_Publish, Content: pubBytes})
+
+	select {
+	case reason := <-received:
+		testutil.Equals(t, stopReason{Source: "lidar"}, reason)
+	case <-time.After(time.Second):
+		t.Fatal("Typed event not received")
+	}
+
+	// Typed publish
+	published := make(chan *cellaserv.Publish, 1)
+	go func() {
+		_, _, msg, err := common.RecvMessage(server)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		pub := &cellaserv.Publish{}
+		proto.Unmarshal(msg.GetContent(), pub)
+		published <- pub
+	}()
+	testutil.Ok(t, PublishTyped(c, emergencyStop, stopReason{Source: "bumper"}))
+	pub = <-published
+	testutil.Equals(t, emergencyStop.Name, pub.GetEvent())
+	testutil.Equals(t, `{"Source":"bumper"}`, string(pub.GetData()))
+}
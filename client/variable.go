@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+
+	cellaserv "github.com/evolutek/cellaserv3-protobuf"
+)
+
+// Variable is a value of a service. Its changes are published in the
+// <service>.<variable> event, or <service>.<identification>.<variable> for
+// services with an identification, and its value is returned by the
+// get_<variable> method of the service.
+type Variable struct {
+	client *Client
+	name   string
+	event  string
+
+	mtx   sync.RWMutex
+	value interface{}
+}
+
+// NewVariable adds a variable to the service, with an initial value. Must be
+// called before the service is registered.
+func (s *service) NewVariable(name string, value interface{}) *Variable {
+	event := s.Name + "." + name
+	if s.Identification != "" {
+		event = s.Name + "." + s.Identification + "." + name
+	}
+	v := &Variable{
+		client: s.client,
+		name:   name,
+		event:  event,
+		value:  value,
+	}
+	method := "get_" + name
+	s.HandleRequestFunc(method, func(context.Context, *cellaserv.Request) (interface{}, error) {
+		return v.Get(), nil
+	})
+	s.SetDoc(method, fmt.Sprintf("Returns the value of %s.", name))
+	return v
+}
+
+func (v *Variable) String() string {
+	return v.event
+}
+
+// Get returns the value of the variable.
+func (v *Variable) Get() interface{} {
+	v.mtx.RLock()
+	defer v.mtx.RUnlock()
+	return v.value
+}
+
+// Set changes the value of the variable and publishes it, unless it is equal
+// to the current value.
+func (v *Variable) Set(value interface{}) error {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+	if reflect.DeepEqual(v.value, value) {
+		return nil
+	}
+	v.value = value
+	// Published with the lock held so that the events are in order
+	return v.client.Publish(v.event, value)
+}
+
+// Publish publishes the current value of the variable, e.g. after
+// reconnecting to cellaserv.
+func (v *Variable) Publish() error {
+	v.mtx.RLock()
+	defer v.mtx.RUnlock()
+	return v.client.Publish(v.event, v.value)
+}
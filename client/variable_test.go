@@ -0,0 +1,53 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/evolutek/cellaserv3/testutil"
+)
+
+func TestVariable(t *testing.T) {
+	withTestBroker(t, ":4201", func(opts ClientOpts) {
+		srvc := NewClient(opts)
+		defer srvc.Close()
+		s := srvc.NewService("robot", "pal")
+		state := s.NewVariable("state", "idle")
+		srvc.RegisterService(s)
+
+		c := NewClient(opts)
+		defer c.Close()
+		events, cancel, err := c.SubscribeChan("robot.pal.state")
+		testutil.Ok(t, err)
+		defer cancel()
+		time.Sleep(50 * time.Millisecond)
+
+		stub := NewServiceStub(c, "robot", "pal")
+		resp, err := stub.RequestNoData("get_state")
+		testutil.Ok(t, err)
+		testutil.Equals(t, `"idle"`, string(resp))
+
+		testutil.Ok(t, state.Set("moving"))
+		// Unchanged, not published
+		testutil.Ok(t, state.Set("moving"))
+		testutil.Ok(t, state.Set("idle"))
+
+		for _, expected := range []string{`"moving"`, `"idle"`} {
+			select {
+			case e := <-events:
+				testutil.Equals(t, expected, string(e.Data))
+			case <-time.After(time.Second):
+				t.Fatal("Variable change not published")
+			}
+		}
+		select {
+		case e := <-events:
+			t.Fatalf("Unexpected event: %s", e.Data)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		resp, err = stub.RequestNoData("get_state")
+		testutil.Ok(t, err)
+		testutil.Equals(t, `"idle"`, string(resp))
+	})
+}
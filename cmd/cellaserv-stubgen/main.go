@@ -0,0 +1,52 @@
+// Generates typed Go stubs of cellaserv services.
+//
+// Usage with go generate:
+//
+//	//go:generate go run github.com/evolutek/cellaserv3/cmd/cellaserv-stubgen -i date.json -o date_stub.go
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/evolutek/cellaserv3/client/stubgen"
+	"github.com/evolutek/cellaserv3/common"
+	"github.com/pkg/errors"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+func main() {
+	a := kingpin.New(filepath.Base(os.Args[0]), "Generate typed stubs of cellaserv services")
+	a.Version(common.GetVersion())
+	a.HelpFlag.Short('h')
+
+	input := a.Flag("input", "JSON description of the service.").Short('i').Required().String()
+	output := a.Flag("output", "Generated Go file, stdout if empty.").Short('o').String()
+
+	_, err := a.Parse(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, errors.Wrapf(err, "Could not parse command line arguments"))
+		a.Usage(os.Args[1:])
+		os.Exit(2)
+	}
+
+	f, err := os.Open(*input)
+	kingpin.FatalIfError(err, "Could not open service description")
+	defer f.Close()
+
+	service, err := stubgen.Parse(f)
+	kingpin.FatalIfError(err, "Invalid service description")
+
+	src, err := stubgen.Generate(service)
+	kingpin.FatalIfError(err, "Could not generate stub")
+
+	if *output == "" {
+		os.Stdout.Write(src)
+		return
+	}
+	err = ioutil.WriteFile(*output, src, 0644)
+	kingpin.FatalIfError(err, "Could not write stub")
+}
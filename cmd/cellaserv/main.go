@@ -49,6 +49,9 @@ func main() {
 	a.Flag("listen-addr", "listening address of the server").
 		Default(":4200").
 		StringVar(&brokerOptions.ListenAddress)
+	a.Flag("keepalive-timeout", "disconnect clients sending keepalive pings after this duration of silence, 0 to disable").
+		Default("0s").
+		DurationVar(&brokerOptions.KeepaliveTimeout)
 
 	// Publish logging
 	a.Flag("store-logs", "whether to store logs, enables using cellaserv.get_logs()").
@@ -113,7 +113,7 @@ func main() {
 			conn.Publish(*publishEvent, *publishArgs)
 		}
 	case "subscribe":
-		err := conn.Subscribe(*subscribeEventPattern,
+		_, err := conn.Subscribe(*subscribeEventPattern,
 			func(eventName string, eventBytes []byte) {
 				fmt.Printf("%s: %s\n", eventName, string(eventBytes))
 
@@ -127,7 +127,7 @@ func main() {
 	case "log":
 		// Log with follow is just a special case of "subscribe"
 		if *logFolow {
-			err := conn.Subscribe("log."+*logPattern,
+			_, err := conn.Subscribe("log."+*logPattern,
 				func(eventName string, eventBytes []byte) {
 					fmt.Printf("%s: %s\n", eventName, string(eventBytes))
 				})
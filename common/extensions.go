@@ -0,0 +1,266 @@
+package common
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Protocol extension fields. They are stored as unknown fields of the
+// cellaserv3-protobuf messages: peers that do not know about them ignore them,
+// and the broker forwards them untouched since it forwards the received bytes.
+const (
+	// Content type of the data of a Request, Reply or Publish. The data is
+	// JSON when absent.
+	fieldContentType protowire.Number = 100
+	// Deadline of a Request, in nanoseconds since the Unix epoch, after
+	// which the requester no longer waits for the reply.
+	fieldDeadline protowire.Number = 101
+	// Set on the partial replies of a streamed reply. The request is
+	// complete when a Reply without this field is received.
+	fieldPartial protowire.Number = 102
+	// Identifier of a Publish to acknowledge. cellaserv acknowledges the
+	// publish with a Reply having this id.
+	fieldAckId protowire.Number = 103
+	// AckMode of a Publish to acknowledge.
+	fieldAckMode protowire.Number = 104
+	// Priority of a Request, zigzag encoded. PriorityNormal when absent.
+	fieldPriority protowire.Number = 105
+	// Trace id of a Request or Publish, shared by all the requests and
+	// publishes made on behalf of the same action.
+	fieldTraceId protowire.Number = 106
+)
+
+// Priority of a request. Higher priority requests, e.g. motion control, are
+// handled ahead of lower priority ones, e.g. introspection.
+type Priority int64
+
+const (
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1
+)
+
+// AckMode selects when cellaserv acknowledges a publish.
+type AckMode uint64
+
+const (
+	// AckNone disables the acknowledgment, the default.
+	AckNone AckMode = iota
+	// AckReceipt acknowledges the publish when cellaserv receives it.
+	AckReceipt
+	// AckFanout acknowledges the publish once cellaserv has sent it to all
+	// the subscribers. The data of the acknowledgment is the JSON encoded
+	// number of subscribers.
+	AckFanout
+)
+
+// GetExtensionBytes returns the value of a length-delimited extension field.
+func GetExtensionBytes(m proto.Message, num protowire.Number) ([]byte, bool) {
+	b, ok := findField(proto.MessageReflect(m).GetUnknown(), num, protowire.BytesType)
+	if !ok {
+		return nil, false
+	}
+	v, n := protowire.ConsumeBytes(b)
+	if n < 0 {
+		return nil, false
+	}
+	return v, true
+}
+
+// SetExtensionBytes sets the value of a length-delimited extension field.
+func SetExtensionBytes(m proto.Message, num protowire.Number, v []byte) {
+	setField(m, num, protowire.BytesType, protowire.AppendBytes(nil, v))
+}
+
+// GetExtensionVarint returns the value of a varint extension field.
+func GetExtensionVarint(m proto.Message, num protowire.Number) (uint64, bool) {
+	b, ok := findField(proto.MessageReflect(m).GetUnknown(), num, protowire.VarintType)
+	if !ok {
+		return 0, false
+	}
+	v, n := protowire.ConsumeVarint(b)
+	if n < 0 {
+		return 0, false
+	}
+	return v, true
+}
+
+// SetExtensionVarint sets the value of a varint extension field.
+func SetExtensionVarint(m proto.Message, num protowire.Number, v uint64) {
+	setField(m, num, protowire.VarintType, protowire.AppendVarint(nil, v))
+}
+
+// ClearExtension removes an extension field.
+func ClearExtension(m proto.Message, num protowire.Number) {
+	msg := proto.MessageReflect(m)
+	msg.SetUnknown(removeField(msg.GetUnknown(), num))
+}
+
+// GetContentType returns the content type of the message data, or the empty
+// string for JSON.
+func GetContentType(m proto.Message) string {
+	v, _ := GetExtensionBytes(m, fieldContentType)
+	return string(v)
+}
+
+// SetContentType sets the content type of the message data. The empty string
+// stands for JSON.
+func SetContentType(m proto.Message, contentType string) {
+	if contentType == "" {
+		ClearExtension(m, fieldContentType)
+		return
+	}
+	SetExtensionBytes(m, fieldContentType, []byte(contentType))
+}
+
+// GetDeadline returns the deadline of the request, if any.
+func GetDeadline(m proto.Message) (time.Time, bool) {
+	v, ok := GetExtensionVarint(m, fieldDeadline)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(v)), true
+}
+
+// SetDeadline sets the deadline of the request. The zero time clears it.
+func SetDeadline(m proto.Message, deadline time.Time) {
+	if deadline.IsZero() {
+		ClearExtension(m, fieldDeadline)
+		return
+	}
+	SetExtensionVarint(m, fieldDeadline, uint64(deadline.UnixNano()))
+}
+
+// IsPartialReply returns whether the reply is a partial reply, followed by
+// other replies to the same request.
+func IsPartialReply(rep proto.Message) bool {
+	v, _ := GetExtensionVarint(rep, fieldPartial)
+	return v != 0
+}
+
+// SetPartialReply marks the reply as partial.
+func SetPartialReply(rep proto.Message, partial bool) {
+	if !partial {
+		ClearExtension(rep, fieldPartial)
+		return
+	}
+	SetExtensionVarint(rep, fieldPartial, 1)
+}
+
+// GetPublishAck returns the acknowledgment id and mode of the publish. The
+// mode is AckNone if the publish is not to be acknowledged.
+func GetPublishAck(pub proto.Message) (uint64, AckMode) {
+	id, ok := GetExtensionVarint(pub, fieldAckId)
+	if !ok {
+		return 0, AckNone
+	}
+	mode, _ := GetExtensionVarint(pub, fieldAckMode)
+	return id, AckMode(mode)
+}
+
+// SetPublishAck requests cellaserv to acknowledge the publish with a Reply
+// having the given id. AckNone clears the request.
+func SetPublishAck(pub proto.Message, id uint64, mode AckMode) {
+	if mode == AckNone {
+		ClearExtension(pub, fieldAckId)
+		ClearExtension(pub, fieldAckMode)
+		return
+	}
+	SetExtensionVarint(pub, fieldAckId, id)
+	SetExtensionVarint(pub, fieldAckMode, uint64(mode))
+}
+
+// GetPriority returns the priority of the request.
+func GetPriority(req proto.Message) Priority {
+	v, _ := GetExtensionVarint(req, fieldPriority)
+	return Priority(protowire.DecodeZigZag(v))
+}
+
+// SetPriority sets the priority of the request.
+func SetPriority(req proto.Message, priority Priority) {
+	if priority == PriorityNormal {
+		ClearExtension(req, fieldPriority)
+		return
+	}
+	SetExtensionVarint(req, fieldPriority, protowire.EncodeZigZag(int64(priority)))
+}
+
+// GetTraceId returns the trace id of the request or publish, or the empty
+// string if it has none.
+func GetTraceId(m proto.Message) string {
+	v, _ := GetExtensionBytes(m, fieldTraceId)
+	return string(v)
+}
+
+// SetTraceId sets the trace id of the request or publish. The empty string
+// clears it.
+func SetTraceId(m proto.Message, traceId string) {
+	if traceId == "" {
+		ClearExtension(m, fieldTraceId)
+		return
+	}
+	SetExtensionBytes(m, fieldTraceId, []byte(traceId))
+}
+
+// NewTraceId returns a new random trace id.
+func NewTraceId() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func setField(m proto.Message, num protowire.Number, typ protowire.Type, value []byte) {
+	msg := proto.MessageReflect(m)
+	unknown := removeField(msg.GetUnknown(), num)
+	unknown = protowire.AppendTag(unknown, num, typ)
+	unknown = append(unknown, value...)
+	msg.SetUnknown(unknown)
+}
+
+// findField returns the encoded value of the last occurrence of the field in
+// the raw fields.
+func findField(b []byte, num protowire.Number, typ protowire.Type) ([]byte, bool) {
+	var value []byte
+	found := false
+	for len(b) > 0 {
+		n, t, tagLen := protowire.ConsumeTag(b)
+		if tagLen < 0 {
+			break
+		}
+		valueLen := protowire.ConsumeFieldValue(n, t, b[tagLen:])
+		if valueLen < 0 {
+			break
+		}
+		if n == num && t == typ {
+			value = b[tagLen : tagLen+valueLen]
+			found = true
+		}
+		b = b[tagLen+valueLen:]
+	}
+	return value, found
+}
+
+// removeField returns the raw fields without the occurrences of the field.
+// Malformed trailing data is dropped.
+func removeField(b []byte, num protowire.Number) []byte {
+	var out []byte
+	for len(b) > 0 {
+		n, t, tagLen := protowire.ConsumeTag(b)
+		if tagLen < 0 {
+			break
+		}
+		valueLen := protowire.ConsumeFieldValue(n, t, b[tagLen:])
+		if valueLen < 0 {
+			break
+		}
+		if n != num {
+			out = append(out, b[:tagLen+valueLen]...)
+		}
+		b = b[tagLen+valueLen:]
+	}
+	return out
+}
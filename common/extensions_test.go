@@ -0,0 +1,97 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	cellaserv "github.com/evolutek/cellaserv3-protobuf"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestExtensionsRoundTrip(t *testing.T) {
+	req := &cellaserv.Request{ServiceName: "date", Method: "time", Id: 42}
+	SetContentType(req, "application/msgpack")
+	SetExtensionVarint(req, 1000, 7)
+	// Setting a field again replaces its value
+	SetExtensionVarint(req, 1000, 8)
+
+	reqBytes, err := proto.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var received cellaserv.Request
+	if err := proto.Unmarshal(reqBytes, &received); err != nil {
+		t.Fatal(err)
+	}
+	if received.Method != "time" || received.Id != 42 {
+		t.Fatalf("Invalid request fields: %v", received.String())
+	}
+	if ct := GetContentType(&received); ct != "application/msgpack" {
+		t.Fatalf("Invalid content type: %q", ct)
+	}
+	if v, ok := GetExtensionVarint(&received, 1000); !ok || v != 8 {
+		t.Fatalf("Invalid extension value: %d, %t", v, ok)
+	}
+
+	SetContentType(&received, "")
+	if ct := GetContentType(&received); ct != "" {
+		t.Fatalf("Content type was not cleared: %q", ct)
+	}
+	if _, ok := GetExtensionVarint(&received, 1000); !ok {
+		t.Fatal("Clearing the content type removed another extension")
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	req := &cellaserv.Request{}
+	if _, ok := GetDeadline(req); ok {
+		t.Fatal("Unexpected deadline")
+	}
+
+	deadline := time.Unix(1600000000, 42)
+	SetDeadline(req, deadline)
+	if d, ok := GetDeadline(req); !ok || !d.Equal(deadline) {
+		t.Fatalf("Invalid deadline: %s, %t", d, ok)
+	}
+
+	SetDeadline(req, time.Time{})
+	if _, ok := GetDeadline(req); ok {
+		t.Fatal("Deadline was not cleared")
+	}
+}
+
+func TestPriority(t *testing.T) {
+	req := &cellaserv.Request{}
+	if p := GetPriority(req); p != PriorityNormal {
+		t.Fatalf("Invalid default priority: %d", p)
+	}
+	for _, p := range []Priority{PriorityLow, PriorityHigh, 10, PriorityNormal} {
+		SetPriority(req, p)
+		if got := GetPriority(req); got != p {
+			t.Fatalf("Invalid priority: %d, expected %d", got, p)
+		}
+	}
+	if len(proto.MessageReflect(req).GetUnknown()) != 0 {
+		t.Fatal("Normal priority was not cleared")
+	}
+}
+
+func TestTraceId(t *testing.T) {
+	pub := &cellaserv.Publish{}
+	if id := GetTraceId(pub); id != "" {
+		t.Fatalf("Unexpected trace id: %q", id)
+	}
+	id := NewTraceId()
+	if id == "" || id == NewTraceId() {
+		t.Fatalf("Invalid trace id: %q", id)
+	}
+	SetTraceId(pub, id)
+	if got := GetTraceId(pub); got != id {
+		t.Fatalf("Invalid trace id: %q, expected %q", got, id)
+	}
+	SetTraceId(pub, "")
+	if len(proto.MessageReflect(pub).GetUnknown()) != 0 {
+		t.Fatal("Trace id was not cleared")
+	}
+}
@@ -0,0 +1,144 @@
+package common
+
+import (
+	"fmt"
+	"os"
+)
+
+// LogLevel is the severity of a log message.
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+	LevelFatal
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	case LevelFatal:
+		return "fatal"
+	default:
+		return fmt.Sprintf("LogLevel(%d)", int(l))
+	}
+}
+
+// LogFunc writes a log message with the given level.
+type LogFunc func(level LogLevel, msg string)
+
+// NewFuncLogger returns a Logger writing its messages with fn. It is the
+// base of the adapters to other logging libraries. The program exits after
+// logging a fatal message.
+func NewFuncLogger(fn LogFunc) Logger {
+	return funcLogger(fn)
+}
+
+type funcLogger LogFunc
+
+func (l funcLogger) log(level LogLevel, msg string) {
+	l(level, msg)
+	if level == LevelFatal {
+		os.Exit(1)
+	}
+}
+
+func (l funcLogger) Debug(args ...interface{})   { l.log(LevelDebug, fmt.Sprint(args...)) }
+func (l funcLogger) Debugln(args ...interface{}) { l.log(LevelDebug, sprintln(args...)) }
+func (l funcLogger) Debugf(format string, args ...interface{}) {
+	l.log(LevelDebug, fmt.Sprintf(format, args...))
+}
+
+func (l funcLogger) Info(args ...interface{})   { l.log(LevelInfo, fmt.Sprint(args...)) }
+func (l funcLogger) Infoln(args ...interface{}) { l.log(LevelInfo, sprintln(args...)) }
+func (l funcLogger) Infof(format string, args ...interface{}) {
+	l.log(LevelInfo, fmt.Sprintf(format, args...))
+}
+
+func (l funcLogger) Warn(args ...interface{})   { l.log(LevelWarn, fmt.Sprint(args...)) }
+func (l funcLogger) Warnln(args ...interface{}) { l.log(LevelWarn, sprintln(args...)) }
+func (l funcLogger) Warnf(format string, args ...interface{}) {
+	l.log(LevelWarn, fmt.Sprintf(format, args...))
+}
+
+func (l funcLogger) Error(args ...interface{})   { l.log(LevelError, fmt.Sprint(args...)) }
+func (l funcLogger) Errorln(args ...interface{}) { l.log(LevelError, sprintln(args...)) }
+func (l funcLogger) Errorf(format string, args ...interface{}) {
+	l.log(LevelError, fmt.Sprintf(format, args...))
+}
+
+func (l funcLogger) Fatal(args ...interface{})   { l.log(LevelFatal, fmt.Sprint(args...)) }
+func (l funcLogger) Fatalln(args ...interface{}) { l.log(LevelFatal, sprintln(args...)) }
+func (l funcLogger) Fatalf(format string, args ...interface{}) {
+	l.log(LevelFatal, fmt.Sprintf(format, args...))
+}
+
+// sprintln is fmt.Sprintln without the trailing newline.
+func sprintln(args ...interface{}) string {
+	msg := fmt.Sprintln(args...)
+	return msg[:len(msg)-1]
+}
+
+// SugaredLogger is the printf-style interface of zap's SugaredLogger.
+type SugaredLogger interface {
+	Debugf(string, ...interface{})
+	Infof(string, ...interface{})
+	Warnf(string, ...interface{})
+	Errorf(string, ...interface{})
+	Fatalf(string, ...interface{})
+}
+
+// NewSugaredLogger adapts a zap SugaredLogger, or any logger with the same
+// printf-style methods, to the Logger interface.
+func NewSugaredLogger(l SugaredLogger) Logger {
+	return NewFuncLogger(func(level LogLevel, msg string) {
+		switch level {
+		case LevelDebug:
+			l.Debugf("%s", msg)
+		case LevelInfo:
+			l.Infof("%s", msg)
+		case LevelWarn:
+			l.Warnf("%s", msg)
+		case LevelError:
+			l.Errorf("%s", msg)
+		case LevelFatal:
+			l.Fatalf("%s", msg)
+		}
+	})
+}
+
+// GoLoggingLogger is the interface of the op/go-logging Logger.
+type GoLoggingLogger interface {
+	Debugf(string, ...interface{})
+	Infof(string, ...interface{})
+	Warningf(string, ...interface{})
+	Errorf(string, ...interface{})
+	Fatalf(string, ...interface{})
+}
+
+// NewGoLoggingLogger adapts an op/go-logging Logger to the Logger interface.
+func NewGoLoggingLogger(l GoLoggingLogger) Logger {
+	return NewFuncLogger(func(level LogLevel, msg string) {
+		switch level {
+		case LevelDebug:
+			l.Debugf("%s", msg)
+		case LevelInfo:
+			l.Infof("%s", msg)
+		case LevelWarn:
+			l.Warningf("%s", msg)
+		case LevelError:
+			l.Errorf("%s", msg)
+		case LevelFatal:
+			l.Fatalf("%s", msg)
+		}
+	})
+}
@@ -0,0 +1,60 @@
+package common
+
+import (
+	"fmt"
+	"testing"
+)
+
+type recordedLog struct {
+	level LogLevel
+	msg   string
+}
+
+func TestFuncLogger(t *testing.T) {
+	var logs []recordedLog
+	l := NewFuncLogger(func(level LogLevel, msg string) {
+		logs = append(logs, recordedLog{level, msg})
+	})
+
+	l.Debug("a", "b")
+	l.Infoln("a", "b")
+	l.Warnf("%d", 42)
+	l.Error("oops")
+
+	expected := []recordedLog{
+		{LevelDebug, "ab"},
+		{LevelInfo, "a b"},
+		{LevelWarn, "42"},
+		{LevelError, "oops"},
+	}
+	if fmt.Sprint(logs) != fmt.Sprint(expected) {
+		t.Fatalf("Got %v, expected %v", logs, expected)
+	}
+}
+
+type fakeGoLogging struct {
+	lines []string
+}
+
+func (l *fakeGoLogging) record(level, format string, args ...interface{}) {
+	l.lines = append(l.lines, level+": "+fmt.Sprintf(format, args...))
+}
+
+func (l *fakeGoLogging) Debugf(f string, a ...interface{})   { l.record("DEBUG", f, a...) }
+func (l *fakeGoLogging) Infof(f string, a ...interface{})    { l.record("INFO", f, a...) }
+func (l *fakeGoLogging) Warningf(f string, a ...interface{}) { l.record("WARNING", f, a...) }
+func (l *fakeGoLogging) Errorf(f string, a ...interface{})   { l.record("ERROR", f, a...) }
+func (l *fakeGoLogging) Fatalf(f string, a ...interface{})   { l.record("FATAL", f, a...) }
+
+func TestGoLoggingLogger(t *testing.T) {
+	fake := &fakeGoLogging{}
+	l := NewGoLoggingLogger(fake)
+
+	l.Warnf("100%% %s", "done")
+	l.Debug("debug")
+
+	expected := []string{"WARNING: 100% done", "DEBUG: debug"}
+	if fmt.Sprint(fake.lines) != fmt.Sprint(expected) {
+		t.Fatalf("Got %v, expected %v", fake.lines, expected)
+	}
+}
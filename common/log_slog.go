@@ -0,0 +1,29 @@
+//go:build go1.21
+
+package common
+
+import (
+	"context"
+	"log/slog"
+)
+
+// NewSlogLogger adapts a log/slog Logger to the Logger interface. Fatal
+// messages are logged with the error level.
+func NewSlogLogger(l *slog.Logger) Logger {
+	return NewFuncLogger(func(level LogLevel, msg string) {
+		l.Log(context.Background(), slogLevel(level), msg)
+	})
+}
+
+func slogLevel(level LogLevel) slog.Level {
+	switch level {
+	case LevelDebug:
+		return slog.LevelDebug
+	case LevelInfo:
+		return slog.LevelInfo
+	case LevelWarn:
+		return slog.LevelWarn
+	default:
+		return slog.LevelError
+	}
+}
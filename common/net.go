@@ -3,6 +3,7 @@ package common
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -46,7 +47,7 @@ func RecvMessage(conn net.Conn) (closed bool, msgBytes []byte, msg *cellaserv.Me
 		if err == io.EOF {
 			return true, nil, nil, nil
 		}
-		err = fmt.Errorf("Could not read message length: %s", err)
+		err = fmt.Errorf("Could not read message length: %w", err)
 		return
 	}
 
@@ -58,10 +59,12 @@ func RecvMessage(conn net.Conn) (closed bool, msgBytes []byte, msg *cellaserv.Me
 
 	// Extract message from connection
 	msgBytes = make([]byte, msgLen)
-	_, err = conn.Read(msgBytes)
+	_, err = io.ReadFull(conn, msgBytes)
 	if err != nil {
-		err = fmt.Errorf("Could not read message: %s", err)
-		return err == io.EOF, nil, nil, nil
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return true, nil, nil, nil
+		}
+		return false, nil, nil, fmt.Errorf("Could not read message: %w", err)
 	}
 
 	// Parse message header
@@ -74,3 +77,10 @@ func RecvMessage(conn net.Conn) (closed bool, msgBytes []byte, msg *cellaserv.Me
 
 	return
 }
+
+// IsTimeout returns whether the error was caused by a connection deadline
+// being exceeded.
+func IsTimeout(err error) bool {
+	var nerr net.Error
+	return errors.As(err, &nerr) && nerr.Timeout()
+}
@@ -0,0 +1,137 @@
+package common
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Pipe returns the two ends of an in-memory connection. Unlike net.Pipe,
+// writes are buffered and never block, as with a TCP connection, so that two
+// peers writing to each other do not deadlock. The first connection has the
+// local address addr1 and the remote address addr2.
+func Pipe(addr1, addr2 string) (net.Conn, net.Conn) {
+	a, b := newPipeBuffer(), newPipeBuffer()
+	return &pipeConn{in: a, out: b, local: pipeAddr(addr1), remote: pipeAddr(addr2)},
+		&pipeConn{in: b, out: a, local: pipeAddr(addr2), remote: pipeAddr(addr1)}
+}
+
+type pipeAddr string
+
+func (pipeAddr) Network() string  { return "pipe" }
+func (a pipeAddr) String() string { return string(a) }
+
+// pipeBuffer is one direction of a pipe.
+type pipeBuffer struct {
+	mtx  sync.Mutex
+	cond *sync.Cond
+	buf  bytes.Buffer
+	// The writer closed the pipe, reads return io.EOF once the buffer is
+	// drained
+	writerClosed bool
+	// The reader closed the pipe, reads and writes fail
+	readerClosed bool
+	deadline     time.Time
+	timer        *time.Timer
+}
+
+func newPipeBuffer() *pipeBuffer {
+	p := &pipeBuffer{}
+	p.cond = sync.NewCond(&p.mtx)
+	return p
+}
+
+func (p *pipeBuffer) read(b []byte) (int, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for {
+		if p.readerClosed {
+			return 0, io.ErrClosedPipe
+		}
+		if p.buf.Len() > 0 {
+			return p.buf.Read(b)
+		}
+		if p.writerClosed {
+			return 0, io.EOF
+		}
+		if !p.deadline.IsZero() && !time.Now().Before(p.deadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+		p.cond.Wait()
+	}
+}
+
+func (p *pipeBuffer) write(b []byte) (int, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if p.writerClosed || p.readerClosed {
+		return 0, io.ErrClosedPipe
+	}
+	p.buf.Write(b)
+	p.cond.Broadcast()
+	return len(b), nil
+}
+
+func (p *pipeBuffer) setDeadline(t time.Time) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.deadline = t
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	if !t.IsZero() {
+		// Wake up the reader to check the deadline
+		p.timer = time.AfterFunc(time.Until(t), func() {
+			p.mtx.Lock()
+			p.cond.Broadcast()
+			p.mtx.Unlock()
+		})
+	}
+	p.cond.Broadcast()
+}
+
+func (p *pipeBuffer) close(reader bool) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if reader {
+		p.readerClosed = true
+	} else {
+		p.writerClosed = true
+	}
+	p.cond.Broadcast()
+}
+
+type pipeConn struct {
+	in, out       *pipeBuffer
+	local, remote pipeAddr
+}
+
+func (c *pipeConn) Read(b []byte) (int, error)  { return c.in.read(b) }
+func (c *pipeConn) Write(b []byte) (int, error) { return c.out.write(b) }
+
+func (c *pipeConn) Close() error {
+	c.in.close(true)
+	c.out.close(false)
+	return nil
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return c.local }
+func (c *pipeConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *pipeConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *pipeConn) SetReadDeadline(t time.Time) error {
+	c.in.setDeadline(t)
+	return nil
+}
+
+// SetWriteDeadline does nothing, writes never block.
+func (c *pipeConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,49 @@
+package common
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestPipe(t *testing.T) {
+	a, b := Pipe("a", "b")
+	if a.LocalAddr().String() != "a" || a.RemoteAddr().String() != "b" {
+		t.Fatalf("Invalid addresses: %s, %s", a.LocalAddr(), a.RemoteAddr())
+	}
+
+	// Writes do not wait for the peer to read
+	for _, msg := range []string{"foo", "bar"} {
+		if _, err := a.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf := make([]byte, 6)
+	if _, err := io.ReadFull(b, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "foobar" {
+		t.Fatalf("Invalid data read: %q", buf)
+	}
+
+	// Read deadline
+	b.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, err := b.Read(buf); !IsTimeout(err) {
+		t.Fatalf("Expected a timeout, got: %v", err)
+	}
+	b.SetReadDeadline(time.Time{})
+
+	// The peer reads the buffered data, then io.EOF
+	a.Write([]byte("baz"))
+	a.Close()
+	n, err := b.Read(buf)
+	if err != nil || string(buf[:n]) != "baz" {
+		t.Fatalf("Invalid read after close: %q, %v", buf[:n], err)
+	}
+	if _, err := b.Read(buf); err != io.EOF {
+		t.Fatalf("Expected io.EOF, got: %v", err)
+	}
+	if _, err := b.Write(buf); err == nil {
+		t.Fatal("Write to a closed pipe succeeded")
+	}
+}
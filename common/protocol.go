@@ -0,0 +1,23 @@
+package common
+
+import (
+	cellaserv "github.com/evolutek/cellaserv3-protobuf"
+)
+
+// Message types extending the ones defined in the cellaserv3-protobuf
+// package. Protobuf enums are open, so peers that do not know about these
+// types see them as unknown message types instead of failing to unmarshal
+// the message.
+const (
+	// MessageUnsubscribe removes a subscription. Its content is a
+	// cellaserv.Subscribe message.
+	MessageUnsubscribe cellaserv.Message_MessageType = 5
+	// MessagePing asks the peer to answer with a MessagePong carrying the
+	// same content. It is used to detect dead connections.
+	MessagePing cellaserv.Message_MessageType = 6
+	// MessagePong answers a MessagePing.
+	MessagePong cellaserv.Message_MessageType = 7
+	// MessageUnregister removes a service registered by the client. Its
+	// content is a cellaserv.Register message.
+	MessageUnregister cellaserv.Message_MessageType = 8
+)
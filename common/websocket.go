@@ -0,0 +1,75 @@
+package common
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// webSocketConn adapts a WebSocket connection to a net.Conn carrying the
+// cellaserv stream. Each write is sent as one binary WebSocket message and
+// reads consume the received messages in order.
+type webSocketConn struct {
+	*websocket.Conn
+
+	readMtx sync.Mutex
+	reader  io.Reader
+
+	writeMtx sync.Mutex
+}
+
+// NewWebSocketConn returns a net.Conn reading and writing the cellaserv
+// protocol framed over the WebSocket connection.
+func NewWebSocketConn(ws *websocket.Conn) net.Conn {
+	return &webSocketConn{Conn: ws}
+}
+
+func (c *webSocketConn) Read(b []byte) (int, error) {
+	c.readMtx.Lock()
+	defer c.readMtx.Unlock()
+
+	for {
+		if c.reader == nil {
+			typ, r, err := c.NextReader()
+			if err != nil {
+				if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					return 0, io.EOF
+				}
+				return 0, err
+			}
+			if typ != websocket.BinaryMessage {
+				continue
+			}
+			c.reader = r
+		}
+		n, err := c.reader.Read(b)
+		if err == io.EOF {
+			c.reader = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (c *webSocketConn) Write(b []byte) (int, error) {
+	c.writeMtx.Lock()
+	defer c.writeMtx.Unlock()
+
+	if err := c.WriteMessage(websocket.BinaryMessage, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *webSocketConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
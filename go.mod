@@ -12,6 +12,7 @@ require (
 	github.com/prometheus/common v0.15.0
 	github.com/rs/cors v1.7.0
 	github.com/sirupsen/logrus v1.7.0
-	golang.org/x/exp v0.0.0-20201215153530-b5a6e247da10 // indirect
+	github.com/vmihailenco/msgpack/v5 v5.3.5
+	google.golang.org/protobuf v1.23.0
 	gopkg.in/alecthomas/kingpin.v2 v2.2.6
 )
@@ -18,7 +18,7 @@ func WithTestBroker(t *testing.T, listenAddress string, testFn func(client.Clien
 	go func() {
 		err := broker.Run(ctxBroker)
 		if err != nil {
-			t.Fatalf("Could not start broker: %s", err)
+			t.Errorf("Could not start broker: %s", err)
 		}
 	}()
 
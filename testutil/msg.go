@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	cellaserv "github.com/evolutek/cellaserv3-protobuf"
+	"github.com/evolutek/cellaserv3/common"
 	"github.com/golang/protobuf/proto"
 )
 
@@ -53,12 +54,34 @@ func MakeMessagePublish(t *testing.T, topic string) []byte {
 	return makeMessage(t, msgType, msgContent)
 }
 
+func MakeMessagePublishAck(t *testing.T, topic string, ackId uint64, mode common.AckMode) []byte {
+	msgType := cellaserv.Message_Publish
+	msgContent := &cellaserv.Publish{Event: topic}
+	common.SetPublishAck(msgContent, ackId, mode)
+	return makeMessage(t, msgType, msgContent)
+}
+
 func MakeMessageSubscribe(t *testing.T, topic string) []byte {
 	msgType := cellaserv.Message_Subscribe
 	msgContent := &cellaserv.Subscribe{Event: topic}
 	return makeMessage(t, msgType, msgContent)
 }
 
+func MakeMessageUnregister(t *testing.T, serviceName string, serviceIdent string) []byte {
+	msgType := common.MessageUnregister
+	msgContent := &cellaserv.Register{
+		Name:           serviceName,
+		Identification: serviceIdent,
+	}
+	return makeMessage(t, msgType, msgContent)
+}
+
+func MakeMessageUnsubscribe(t *testing.T, topic string) []byte {
+	msgType := common.MessageUnsubscribe
+	msgContent := &cellaserv.Subscribe{Event: topic}
+	return makeMessage(t, msgType, msgContent)
+}
+
 func MakeMessageRequest(t *testing.T, service string, ident string, method string, payload []byte) []byte {
 	msgType := cellaserv.Message_Request
 	msgId := atomic.AddUint64(&NextMessageRequestId, 1)